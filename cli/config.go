@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+	"niteo.co/subsetter/subsetter"
+)
+
+// config is a declarative subset configuration, read from a YAML or JSON file.
+//
+//	source: postgres://...
+//	destination: postgres://...
+//	fraction: 0.05
+//	seed: secret
+//	jobs: 4
+//	strategy: linear
+//	min_rows: 10
+//	tablesample: system
+//	stage_keys: true
+//	key_store: keys.db
+//	load_mode: skip
+//	create_schema: true
+//	schema_check: intersect
+//	schemas: [public, audit_*]
+//	tables:
+//	  users:
+//	    include: "id = 1"
+//	    fraction: 0.5
+//	  audit_log:
+//	    max: 10000
+//	  countries:
+//	    rows: 50
+//	  accounts:
+//	    stratify: plan_id
+//	    per_group: 20
+//	  plans:
+//	    all: true
+//	    columns:
+//	      email: fake_email
+//	      created_at: {type: shift_date, days: 30}
+//	  domains_*:
+//	    exclude: all
+type config struct {
+	Source          string         `yaml:"source"`
+	Destination     string         `yaml:"destination"`
+	Fraction        *fractionValue `yaml:"fraction"`
+	Seed            string         `yaml:"seed"`
+	Jobs            int            `yaml:"jobs"`
+	Strategy        string         `yaml:"strategy"`
+	Rows            int            `yaml:"rows"`
+	MinRows         int            `yaml:"min_rows"`
+	MaxRows         int            `yaml:"max_rows"`
+	TableSample     string         `yaml:"tablesample"`
+	TableSampleRows int            `yaml:"tablesample_rows"`
+	StageKeys       bool           `yaml:"stage_keys"`
+	KeyStore        string         `yaml:"key_store"`
+	LoadMode        string         `yaml:"load_mode"`
+	CreateSchema    bool           `yaml:"create_schema"`
+	SchemaCheck     string         `yaml:"schema_check"`
+	Schemas         schemaList     `yaml:"schemas"`
+	Tables          tablesConfig   `yaml:"tables"`
+}
+
+// tableConfig holds rules for tables matching a pattern.
+type tableConfig struct {
+	Table    string
+	Include  stringList     `yaml:"include"`
+	Exclude  stringList     `yaml:"exclude"`
+	Strategy string         `yaml:"strategy"`
+	Fraction *fractionValue `yaml:"fraction"`
+	Rows     int            `yaml:"rows"`
+	Min      int            `yaml:"min"`
+	Max      int            `yaml:"max"`
+	All      bool           `yaml:"all"`
+	Stratify string         `yaml:"stratify"`
+	PerGroup int            `yaml:"per_group"`
+	Columns  columnsConfig  `yaml:"columns"`
+}
+
+// columnConfig holds the transform masking a column.
+type columnConfig struct {
+	Column    string
+	Transform subsetter.Transform
+}
+
+// columnsConfig is a list of column transforms, in the order of the file.
+type columnsConfig []columnConfig
+
+// transformNames lists the transforms that can be used for columns.
+var transformNames = []string{"fake_email", "hash", "nullify", "fixed", "keep_format", "shift_date", "regex_replace"}
+
+// tablesConfig is a list of table configurations, in the order of the file.
+type tablesConfig []tableConfig
+
+// fractionValue is a fraction of rows between 0 and 1.
+type fractionValue float64
+
+// schemaList is a list of schema glob patterns.
+type schemaList []string
+
+// stringList is a list of strings that can also be written as a single string.
+type stringList []string
+
+// loadConfig reads and validates a configuration file, errors contain line numbers.
+func loadConfig(name string) (*config, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var c config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &c, nil
+}
+
+// options maps the configuration onto sync options. Schemas, include, exclude and sample
+// rules of the configuration are left out when the flag of the same name was set, the
+// flags replace them.
+func (c *config) options(set map[string]bool) (options []subsetter.Option) {
+	if len(c.Schemas) > 0 && !set["schema"] {
+		options = append(options, subsetter.WithSchemas(c.Schemas...))
+	}
+	if c.Seed != "" {
+		options = append(options, subsetter.WithSeed(c.Seed))
+	}
+	for _, table := range c.Tables {
+		for _, where := range lo.Ternary(set["include"], nil, table.Include) {
+			options = append(options, subsetter.WithInclude(subsetter.Rule{Table: table.Table, Where: maybeAll(where)}))
+		}
+		for _, where := range lo.Ternary(set["exclude"], nil, table.Exclude) {
+			options = append(options, subsetter.WithExclude(subsetter.Rule{Table: table.Table, Where: maybeAll(where)}))
+		}
+		if sample := table.sample(); sample != (subsetter.TableSample{Table: table.Table}) && !set["sample"] {
+			options = append(options, subsetter.WithTableSample(sample))
+		}
+		for _, column := range table.Columns {
+			options = append(options, subsetter.WithTransform(table.Table, column.Column, column.Transform))
+		}
+	}
+	return
+}
+
+func (c *config) UnmarshalYAML(value *yaml.Node) error {
+	if err := checkFields(value, "source", "destination", "fraction", "seed", "jobs", "strategy", "rows", "min_rows", "max_rows", "tablesample", "tablesample_rows", "stage_keys", "key_store", "load_mode", "create_schema", "schema_check", "schemas", "tables"); err != nil {
+		return err
+	}
+	type plain config
+	return value.Decode((*plain)(c))
+}
+
+func (t *tablesConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: tables must be a mapping of table names to rules", value.Line)
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		key, body := value.Content[i], value.Content[i+1]
+		if key.Value == "" {
+			return fmt.Errorf("line %d: table name must not be empty", key.Line)
+		}
+		if _, err := path.Match(key.Value, ""); err != nil {
+			return fmt.Errorf("line %d: invalid table pattern %q", key.Line, key.Value)
+		}
+		if err := checkFields(body, "include", "exclude", "strategy", "fraction", "rows", "min", "max", "all", "stratify", "per_group", "columns"); err != nil {
+			return err
+		}
+
+		table := tableConfig{Table: key.Value}
+		if err := body.Decode(&table); err != nil {
+			return err
+		}
+		if table.Rows < 0 || table.Min < 0 || table.Max < 0 || table.PerGroup < 0 {
+			return fmt.Errorf("line %d: rows, min, max and per_group must be positive numbers of rows", body.Line)
+		}
+		sample := table.sample()
+		if err := checkSample(&sample); err != nil {
+			return fmt.Errorf("line %d: %w", body.Line, err)
+		}
+		table.Strategy = string(sample.Strategy)
+		*t = append(*t, table)
+	}
+	return nil
+}
+
+// sample returns the sample override of the table.
+func (t *tableConfig) sample() subsetter.TableSample {
+	sample := subsetter.TableSample{
+		Table:    t.Table,
+		Strategy: subsetter.SampleStrategy(t.Strategy),
+		Rows:     t.Rows,
+		Min:      t.Min,
+		Max:      t.Max,
+		All:      t.All,
+		Stratify: t.Stratify,
+		PerGroup: t.PerGroup,
+	}
+	if t.Fraction != nil {
+		sample.Fraction = float64(*t.Fraction)
+	}
+	return sample
+}
+
+func (c *columnsConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: columns must be a mapping of column names to transforms", value.Line)
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		key, body := value.Content[i], value.Content[i+1]
+		transform, err := parseTransform(body)
+		if err != nil {
+			return err
+		}
+		*c = append(*c, columnConfig{Column: key.Value, Transform: transform})
+	}
+	return nil
+}
+
+// parseTransform reads a transform written as its name or as a mapping with its type and options,
+// e.g. {type: regex_replace, pattern: '\d', replace: X}.
+func parseTransform(value *yaml.Node) (subsetter.Transform, error) {
+	var spec struct {
+		Type    string `yaml:"type"`
+		Value   string `yaml:"value"`
+		Days    int    `yaml:"days"`
+		Pattern string `yaml:"pattern"`
+		Replace string `yaml:"replace"`
+	}
+	if value.Kind == yaml.ScalarNode {
+		spec.Type = value.Value
+	} else {
+		if err := checkFields(value, "type", "value", "days", "pattern", "replace"); err != nil {
+			return nil, err
+		}
+		if err := value.Decode(&spec); err != nil {
+			return nil, err
+		}
+	}
+
+	switch spec.Type {
+	case "fake_email":
+		return subsetter.FakeEmail(), nil
+	case "hash":
+		return subsetter.Hash(), nil
+	case "nullify":
+		return subsetter.Nullify(), nil
+	case "fixed":
+		return subsetter.Fixed(spec.Value), nil
+	case "keep_format":
+		return subsetter.KeepFormat(), nil
+	case "shift_date":
+		if spec.Days <= 0 {
+			return nil, fmt.Errorf("line %d: shift_date requires a positive number of days", value.Line)
+		}
+		return subsetter.ShiftDate(spec.Days), nil
+	case "regex_replace":
+		transform, err := subsetter.RegexReplace(spec.Pattern, spec.Replace)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", value.Line, err)
+		}
+		return transform, nil
+	}
+	return nil, fmt.Errorf("line %d: unknown transform %q, expected one of %s", value.Line, spec.Type, strings.Join(transformNames, ", "))
+}
+
+func (f *fractionValue) UnmarshalYAML(value *yaml.Node) error {
+	var v float64
+	if err := value.Decode(&v); err != nil {
+		return err
+	}
+	if v <= 0 || v > 1 {
+		return fmt.Errorf("line %d: fraction must be between 0 and 1", value.Line)
+	}
+	*f = fractionValue(v)
+	return nil
+}
+
+func (s *schemaList) UnmarshalYAML(value *yaml.Node) error {
+	var patterns stringList
+	if err := value.Decode(&patterns); err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("line %d: invalid schema pattern %q", value.Line, pattern)
+		}
+	}
+	*s = schemaList(patterns)
+	return nil
+}
+
+func (s *stringList) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		*s = stringList{value.Value}
+		return nil
+	case yaml.SequenceNode:
+		var values []string
+		if err := value.Decode(&values); err != nil {
+			return err
+		}
+		*s = stringList(values)
+		return nil
+	}
+	return fmt.Errorf("line %d: expected a string or a list of strings", value.Line)
+}
+
+// checkFields validates that a mapping only contains known fields.
+func checkFields(value *yaml.Node, fields ...string) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping with fields %s", value.Line, strings.Join(fields, ", "))
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		key := value.Content[i]
+		if !lo.Contains(fields, key.Value) {
+			return fmt.Errorf("line %d: unknown field %q, expected one of %s", key.Line, key.Value, strings.Join(fields, ", "))
+		}
+	}
+	return nil
+}
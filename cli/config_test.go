@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"niteo.co/subsetter/subsetter"
+)
+
+func writeConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func Test_loadConfig(t *testing.T) {
+	file := writeConfig(t, "subset.yaml", `
+source: postgres://source
+destination: postgres://target
+fraction: 0.5
+seed: secret
+schemas: [public, audit_*]
+tables:
+  users:
+    include:
+      - "id = 1"
+      - "id = 2"
+    fraction: 1
+    columns:
+      email: fake_email
+      phone: {type: regex_replace, pattern: '\d', replace: X}
+  domains_*:
+    exclude: all
+`)
+
+	c, err := loadConfig(file)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if c.Source != "postgres://source" || c.Destination != "postgres://target" {
+		t.Errorf("loadConfig() DSNs = %v, %v", c.Source, c.Destination)
+	}
+	if *c.Fraction != 0.5 {
+		t.Errorf("loadConfig() fraction = %v, want 0.5", *c.Fraction)
+	}
+	if strings.Join(c.Schemas, ",") != "public,audit_*" {
+		t.Errorf("loadConfig() schemas = %v", c.Schemas)
+	}
+	if len(c.Tables) != 2 || c.Tables[0].Table != "users" || c.Tables[1].Table != "domains_*" {
+		t.Fatalf("loadConfig() tables = %v", c.Tables)
+	}
+	if len(c.Tables[0].Include) != 2 || *c.Tables[0].Fraction != 1 {
+		t.Errorf("loadConfig() users = %v", c.Tables[0])
+	}
+	if len(c.Tables[1].Exclude) != 1 || c.Tables[1].Exclude[0] != "all" {
+		t.Errorf("loadConfig() domains = %v", c.Tables[1])
+	}
+	if c.Seed != "secret" || len(c.Tables[0].Columns) != 2 || c.Tables[0].Columns[1].Column != "phone" {
+		t.Errorf("loadConfig() columns = %v", c.Tables[0].Columns)
+	}
+	if got := *c.Tables[0].Columns[1].Transform([]byte(c.Seed), "555-123"); got != "XXX-XXX" {
+		t.Errorf("loadConfig() phone transform = %v, want XXX-XXX", got)
+	}
+	if got := len(c.options(nil)); got != 8 {
+		t.Errorf("config.options() = %v options, want 8", got)
+	}
+	// Flags replace the schemas and rules of the configuration
+	if got := len(c.options(map[string]bool{"schema": true, "include": true, "exclude": true})); got != 4 {
+		t.Errorf("config.options() = %v options with flags set, want 4", got)
+	}
+}
+
+func Test_loadConfigJSON(t *testing.T) {
+	file := writeConfig(t, "subset.json", `{"source": "postgres://source", "tables": {"users": {"include": "id = 1"}}}`)
+
+	c, err := loadConfig(file)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if c.Source != "postgres://source" || len(c.Tables) != 1 || c.Tables[0].Include[0] != "id = 1" {
+		t.Errorf("loadConfig() = %v", c)
+	}
+}
+
+func Test_loadConfigSample(t *testing.T) {
+	file := writeConfig(t, "subset.yaml", `
+strategy: linear
+min_rows: 10
+tables:
+  countries:
+    rows: 50
+  accounts:
+    stratify: plan_id
+    per_group: 20
+`)
+
+	c, err := loadConfig(file)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if c.Strategy != "linear" || c.MinRows != 10 {
+		t.Errorf("loadConfig() strategy = %v, min rows = %v", c.Strategy, c.MinRows)
+	}
+	if got := c.Tables[0].sample(); got != (subsetter.TableSample{Table: "countries", Strategy: subsetter.SampleFixed, Rows: 50}) {
+		t.Errorf("loadConfig() countries sample = %v", got)
+	}
+	if got := c.Tables[1].sample(); got != (subsetter.TableSample{Table: "accounts", Stratify: "plan_id", PerGroup: 20}) {
+		t.Errorf("loadConfig() accounts sample = %v", got)
+	}
+}
+
+func Test_loadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"Unknown field", "source: x\nfractions: 0.5\n", "line 2: unknown field \"fractions\""},
+		{"Unknown table field", "tables:\n  users:\n    where: id = 1\n", "line 3: unknown field \"where\""},
+		{"Fraction out of range", "fraction: 2\n", "line 1: fraction must be between 0 and 1"},
+		{"Table fraction out of range", "tables:\n  users:\n    fraction: 0\n", "line 3: fraction must be between 0 and 1"},
+		{"Invalid type", "fraction: lots\n", "line 1: cannot unmarshal"},
+		{"Invalid schema", "schemas: ['audit_[']\n", "line 1: invalid schema pattern"},
+		{"Unknown transform", "tables:\n  users:\n    columns:\n      email: scramble\n", "line 4: unknown transform \"scramble\""},
+		{"Invalid transform", "tables:\n  users:\n    columns:\n      born: {type: shift_date}\n", "line 4: shift_date requires a positive number of days"},
+		{"Invalid rules", "tables:\n  users:\n    include: {id: 1}\n", "line 3: expected a string or a list of strings"},
+		{"Negative max", "tables:\n  users:\n    max: -1\n", "line 3: rows, min, max and per_group must be positive numbers of rows"},
+		{"All with max", "tables:\n  users:\n    all: true\n    max: 10\n", "line 3: all can't be combined with other options"},
+		{"Unknown strategy", "tables:\n  users:\n    strategy: sqrt\n", "line 3: strategy must be one of"},
+		{"Per group without stratify", "tables:\n  users:\n    per_group: 5\n", "line 3: per_group requires stratify"},
+		{"Fixed without rows", "tables:\n  users:\n    strategy: fixed\n", "line 3: fixed strategy requires a number of rows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, "subset.yaml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
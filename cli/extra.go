@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"path"
+	"strconv"
 	"strings"
 
+	"github.com/samber/lo"
 	"niteo.co/subsetter/subsetter"
 )
 
@@ -35,3 +38,101 @@ func maybeAll(s string) string {
 	}
 	return s
 }
+
+// arraySample is a list of per table sample overrides, e.g. "audit_log: fraction=0.5,max=10000",
+// "accounts: stratify=plan_id,per_group=20" or "plans: all".
+type arraySample []subsetter.TableSample
+
+func (as *arraySample) String() string {
+	return strings.Join(lo.Map(*as, func(sample subsetter.TableSample, _ int) string {
+		return fmt.Sprintf("%s: %s", sample.Table, sample.String())
+	}), ", ")
+}
+
+func (as *arraySample) Set(value string) error {
+	q := strings.SplitN(strings.TrimSpace(value), ":", 2)
+	sample := subsetter.TableSample{Table: strings.TrimSpace(q[0])}
+	if sample.Table == "" || len(q) < 2 {
+		return fmt.Errorf("expected a table and its sample, e.g. 'users: fraction=0.5,max=1000' or 'plans: all'")
+	}
+
+	for _, option := range strings.Split(q[1], ",") {
+		name, v, _ := strings.Cut(strings.TrimSpace(option), "=")
+		var err error
+		switch name {
+		case "all":
+			sample.All = true
+		case "strategy":
+			sample.Strategy = subsetter.SampleStrategy(v)
+		case "fraction":
+			if sample.Fraction, err = strconv.ParseFloat(v, 64); err == nil && (sample.Fraction <= 0 || sample.Fraction > 1) {
+				err = fmt.Errorf("fraction must be between 0 and 1")
+			}
+		case "rows":
+			sample.Rows, err = positiveRows(name, v)
+		case "min":
+			sample.Min, err = positiveRows(name, v)
+		case "max":
+			sample.Max, err = positiveRows(name, v)
+		case "stratify":
+			sample.Stratify = strings.TrimSpace(v)
+		case "per_group":
+			sample.PerGroup, err = positiveRows(name, v)
+		default:
+			err = fmt.Errorf("unknown sample option %q, expected strategy, fraction, rows, min, max, stratify, per_group or all", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid sample %q: %w", value, err)
+		}
+	}
+	if err := checkSample(&sample); err != nil {
+		return fmt.Errorf("invalid sample %q: %w", value, err)
+	}
+
+	*as = append(*as, sample)
+	return nil
+}
+
+// positiveRows parses a positive number of rows.
+func positiveRows(name string, value string) (int, error) {
+	rows, err := strconv.Atoi(value)
+	if err == nil && rows <= 0 {
+		err = fmt.Errorf("%s must be a positive number of rows", name)
+	}
+	return rows, err
+}
+
+// checkSample validates a sample, a number of rows without a strategy selects the fixed strategy.
+func checkSample(sample *subsetter.TableSample) error {
+	if sample.Rows > 0 && sample.Strategy == "" {
+		sample.Strategy = subsetter.SampleFixed
+	}
+	switch {
+	case sample.Strategy != "" && !lo.Contains(subsetter.SampleStrategies, sample.Strategy):
+		return fmt.Errorf("strategy must be one of %v", subsetter.SampleStrategies)
+	case sample.Strategy == subsetter.SampleFixed && sample.Rows == 0:
+		return fmt.Errorf("fixed strategy requires a number of rows")
+	case sample.All && (sample.Strategy != "" || sample.Fraction > 0 || sample.Min > 0 || sample.Max > 0 || sample.Stratify != ""):
+		return fmt.Errorf("all can't be combined with other options")
+	case sample.PerGroup > 0 && sample.Stratify == "":
+		return fmt.Errorf("per_group requires stratify")
+	case sample.Min > 0 && sample.Max > 0 && sample.Min > sample.Max:
+		return fmt.Errorf("min must not be above max")
+	}
+	return nil
+}
+
+type arraySchema []string
+
+func (as *arraySchema) String() string {
+	return strings.Join(*as, ",")
+}
+
+func (as *arraySchema) Set(value string) error {
+	pattern := strings.TrimSpace(value)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid schema pattern %q: %w", pattern, err)
+	}
+	*as = append(*as, pattern)
+	return nil
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"testing"
+
+	"niteo.co/subsetter/subsetter"
 )
 
 func Test_arrayExtra_Set(t *testing.T) {
@@ -29,3 +31,65 @@ func Test_arrayExtra_Set(t *testing.T) {
 		})
 	}
 }
+
+func Test_arraySchema_Set(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		value   string
+		schemas arraySchema
+		wantErr bool
+	}{
+		{"With schema", "billing", arraySchema{"billing"}, false},
+		{"With glob", " audit_* ", arraySchema{"audit_*"}, false},
+		{"With invalid glob", "audit_[", arraySchema{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := arraySchema{}
+			if err := s.Set(tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("arraySchema.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s.String() != tt.schemas.String() {
+				t.Errorf("arraySchema.Set() = %v, want %v", s, tt.schemas)
+			}
+		})
+	}
+}
+
+func Test_arraySample_Set(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		value   string
+		samples arraySample
+		wantErr bool
+	}{
+		{"With fraction", "events: fraction=0.01", arraySample{{Table: "events", Fraction: 0.01}}, false},
+		{"With fraction and max", "audit_log: fraction=0.5, max=10000", arraySample{{Table: "audit_log", Fraction: 0.5, Max: 10000}}, false},
+		{"With all", "plans: all", arraySample{{Table: "plans", All: true}}, false},
+		{"Without sample", "plans", arraySample{}, true},
+		{"With linear strategy", "events: strategy=linear,fraction=0.01,min=10", arraySample{{Table: "events", Strategy: subsetter.SampleLinear, Fraction: 0.01, Min: 10}}, false},
+		{"With rows", "countries: rows=50", arraySample{{Table: "countries", Strategy: subsetter.SampleFixed, Rows: 50}}, false},
+		{"With unknown option", "plans: size=10", arraySample{}, true},
+		{"With unknown strategy", "plans: strategy=sqrt", arraySample{}, true},
+		{"With fixed strategy without rows", "plans: strategy=fixed", arraySample{}, true},
+		{"With min above max", "plans: min=100,max=10", arraySample{}, true},
+		{"With stratify", "accounts: stratify=plan_id, per_group=20", arraySample{{Table: "accounts", Stratify: "plan_id", PerGroup: 20}}, false},
+		{"With per group without stratify", "accounts: per_group=20", arraySample{}, true},
+		{"With fraction out of range", "plans: fraction=2", arraySample{}, true},
+		{"With negative max", "plans: max=-1", arraySample{}, true},
+		{"With all and max", "plans: all,max=10", arraySample{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := arraySample{}
+			if err := s.Set(tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("arraySample.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s.String() != tt.samples.String() {
+				t.Errorf("arraySample.Set() = %v, want %v", s, tt.samples)
+			}
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/rs/zerolog/pkgerrors"
+	"github.com/samber/lo"
 	"niteo.co/subsetter/subsetter"
 )
 
@@ -18,39 +23,173 @@ var (
 
 var src = flag.String("src", "", "Source database DSN")
 var dst = flag.String("dst", "", "Destination database DSN")
-var fraction = flag.Float64("f", 0.05, "Fraction of rows to copy")
+var fraction = flag.Float64("f", subsetter.DefaultFraction, "Fraction of rows to copy")
+var seed = flag.String("seed", "", "Seed picking the same sampled rows and masked values in every sync of the same source")
+var strategy = flag.String("strategy", "", "How the rows to copy scale with the rows of tables: log (default) copies rows^f, linear copies a fraction f of rows, fixed copies -rows rows")
+var rows = flag.Int("rows", 0, "Number of rows to copy of each table, selects the fixed strategy")
+var minRows = flag.Int("min-rows", 0, "Copy at least this many rows of each table, when it has them")
+var maxRows = flag.Int("max-rows", 0, "Copy at most this many rows of each table")
+var tableSample = flag.String("tablesample", "", "Read tables with at least -tablesample-rows rows with TABLESAMPLE system or bernoulli instead of scanning them")
+var tableSampleRows = flag.Int("tablesample-rows", subsetter.DefaultTableSampleRows, "Rows from which tables are read with -tablesample")
+var jobs = flag.Int("jobs", 1, "Number of tables to copy at once")
+var stageKeys = flag.Bool("stage-keys", false, "Stage the keys of every relation in temporary tables on the source instead of sending key lists, relations with many keys are always staged")
+var keyStore = flag.String("key-store", "", "File to keep the keys of copied rows in instead of memory, for large subsets")
+var loadMode = flag.String("load-mode", string(subsetter.LoadCopy), "How to load rows: copy, skip existing rows or update them, skip and update allow rerunning against a filled destination")
+var createSchema = flag.Bool("create-schema", false, "Create tables of the source missing in the destination, with foreign keys and indexes created after copying")
+var schemaCheck = flag.String("schema-check", string(subsetter.SchemaAbort), "When columns differ between source and destination: abort listing the differences, or intersect to copy the common columns")
+var truncate = flag.Bool("truncate", false, "Truncate the tables to be copied in the destination before copying, asks for confirmation")
+var cascade = flag.Bool("cascade", false, "Truncate with CASCADE, also truncating tables referencing the copied tables")
+var yes = flag.Bool("yes", false, "Don't ask for confirmation before truncating")
+var stateFile = flag.String("state", "", "File to keep a checkpoint of the sync in, see -resume")
+var resume = flag.Bool("resume", false, "Continue an interrupted sync from the checkpoint in the -state file")
+var configFile = flag.String("config", "", "Subset configuration file in YAML or JSON format, flags take precedence")
+var dryRun = flag.Bool("dry-run", false, "Print the tables and queries that would be copied without accessing the destination")
+var reportFile = flag.String("report", "", "Write a JSON report of the sync to a file")
 var verbose = flag.Bool("verbose", false, "Show more information during sync")
 var ver = flag.Bool("v", false, "Release information")
+var schemas arraySchema
 var extraInclude arrayExtra
 var extraExclude arrayExtra
+var samples arraySample
 
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
 
+	flag.Var(&schemas, "schema", "Schema to copy tables from 'billing_*', supports globs, can be used multiple times (default public)")
 	flag.Var(&extraInclude, "include", "Query to copy required rows 'users: id = 1', can be used multiple times")
 	flag.Var(&extraExclude, "exclude", "Query to ignore tables 'users: all', can be used multiple times")
-	flag.Parse()
+	flag.Var(&samples, "sample", "Rows to copy of tables 'events: fraction=0.1', 'audit_log: max=10000' or 'plans: all', options are strategy, fraction, rows, min, max, stratify, per_group and all, can be used multiple times")
+	flag.Usage = usage
+
+	// verify is the only subcommand, syncing is the default
+	args := os.Args[1:]
+	verify := len(args) > 0 && args[0] == "verify"
+	if verify {
+		args = args[1:]
+	}
+	_ = flag.CommandLine.Parse(args)
 
 	if *ver {
 		log.Info().Str("version", version).Str("commit", commit).Str("date", date).Msg("Version")
 		os.Exit(0)
 	}
 
-	if *src == "" || *dst == "" {
+	// Samples given as flags replace those of the configuration
+	options := lo.Map(samples, func(sample subsetter.TableSample, _ int) subsetter.Option {
+		return subsetter.WithTableSample(sample)
+	})
+	if *configFile != "" {
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid configuration")
+		}
+
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		if !set["src"] {
+			*src = c.Source
+		}
+		if !set["dst"] {
+			*dst = c.Destination
+		}
+		if !set["f"] && c.Fraction != nil {
+			*fraction = float64(*c.Fraction)
+		}
+		if !set["strategy"] && c.Strategy != "" {
+			*strategy = c.Strategy
+		}
+		if !set["rows"] && c.Rows > 0 {
+			*rows = c.Rows
+		}
+		if !set["min-rows"] && c.MinRows > 0 {
+			*minRows = c.MinRows
+		}
+		if !set["max-rows"] && c.MaxRows > 0 {
+			*maxRows = c.MaxRows
+		}
+		if !set["tablesample"] && c.TableSample != "" {
+			*tableSample = c.TableSample
+		}
+		if !set["tablesample-rows"] && c.TableSampleRows > 0 {
+			*tableSampleRows = c.TableSampleRows
+		}
+		if !set["jobs"] && c.Jobs > 0 {
+			*jobs = c.Jobs
+		}
+		if !set["stage-keys"] {
+			*stageKeys = c.StageKeys
+		}
+		if !set["key-store"] && c.KeyStore != "" {
+			*keyStore = c.KeyStore
+		}
+		if !set["load-mode"] && c.LoadMode != "" {
+			*loadMode = c.LoadMode
+		}
+		if !set["create-schema"] {
+			*createSchema = c.CreateSchema
+		}
+		if !set["schema-check"] && c.SchemaCheck != "" {
+			*schemaCheck = c.SchemaCheck
+		}
+		options = append(options, c.options(set)...)
+	}
+
+	if verify {
+		if *dst == "" {
+			log.Fatal().Msg("Destination DSN is required")
+		}
+		*src = ""
+	} else if *src == "" || (*dst == "" && !*dryRun) {
 		log.Fatal().Msg("Source and destination DSNs are required")
 	}
+	if *dryRun {
+		*dst = ""
+	}
 
 	if *fraction <= 0 || *fraction > 1 {
 		log.Fatal().Msg("Fraction must be between 0 and 1")
 	}
 
+	if *rows < 0 || *minRows < 0 || *maxRows < 0 {
+		log.Fatal().Msg("Rows, minimum and maximum rows must be positive")
+	}
+	sampling := subsetter.TableSample{Strategy: subsetter.SampleStrategy(*strategy), Rows: *rows, Min: *minRows, Max: *maxRows}
+	if err := checkSample(&sampling); err != nil {
+		log.Fatal().Err(err).Msg("Invalid sampling")
+	}
+
+	if *tableSample != "" && !lo.Contains(subsetter.TableSampleMethods, subsetter.TableSampleMethod(*tableSample)) {
+		log.Fatal().Msgf("Table sample must be one of %v", subsetter.TableSampleMethods)
+	}
+
+	if *jobs < 1 {
+		log.Fatal().Msg("Jobs must be at least 1")
+	}
+
+	if !lo.Contains(subsetter.LoadModes, subsetter.LoadMode(*loadMode)) {
+		log.Fatal().Msgf("Load mode must be one of %v", subsetter.LoadModes)
+	}
+
+	if !lo.Contains(subsetter.SchemaChecks, subsetter.SchemaCheck(*schemaCheck)) {
+		log.Fatal().Msgf("Schema check must be one of %v", subsetter.SchemaChecks)
+	}
+
+	if *cascade && !*truncate {
+		log.Fatal().Msg("Cascade requires -truncate")
+	}
+
 	if *verbose {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	} else {
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
 
+	if len(schemas) > 0 {
+		log.Info().Str("schema", schemas.String()).Msg("Copying")
+	}
 	if len(extraInclude) > 0 {
 		log.Info().Str("include", extraInclude.String()).Msg("Forcibly")
 	}
@@ -58,16 +197,133 @@ func main() {
 		log.Info().Str("exclude", extraExclude.String()).Msg("Forcibly")
 	}
 
-	s, err := subsetter.NewSync(*src, *dst, *fraction, extraInclude, extraExclude, *verbose)
+	options = append(options,
+		subsetter.WithFraction(*fraction),
+		subsetter.WithSample(sampling),
+		subsetter.WithTableSampling(subsetter.TableSampleMethod(*tableSample), *tableSampleRows),
+		subsetter.WithSchemas(schemas...),
+		subsetter.WithInclude(extraInclude...),
+		subsetter.WithExclude(extraExclude...),
+		subsetter.WithVerbose(*verbose),
+		subsetter.WithJobs(*jobs),
+		subsetter.WithKeyStaging(*stageKeys),
+		subsetter.WithLoadMode(subsetter.LoadMode(*loadMode)),
+		subsetter.WithSchemaCreation(*createSchema),
+		subsetter.WithSchemaCheck(subsetter.SchemaCheck(*schemaCheck)),
+	)
+	// Comes after the options of the configuration so the flag takes precedence
+	if *seed != "" {
+		options = append(options, subsetter.WithSeed(*seed))
+	}
+
+	if *truncate && !verify {
+		options = append(options, subsetter.WithTruncate(*cascade))
+	}
+
+	if *resume && *stateFile == "" {
+		log.Fatal().Msg("Resuming requires a state file")
+	}
+	if *stateFile != "" && *keyStore != "" {
+		log.Fatal().Msg("State and key store files can't be used together, the state keeps the keys")
+	}
+
+	if *stateFile != "" && !verify && !*dryRun {
+		state, err := subsetter.OpenState(*stateFile, *resume)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open state")
+		}
+		options = append(options, subsetter.WithState(state))
+	}
+	if *keyStore != "" && !verify && !*dryRun {
+		store, err := subsetter.OpenKeyStore(*keyStore)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open key store")
+		}
+		options = append(options, subsetter.WithKeyStore(store))
+	}
+
+	s, err := subsetter.NewSync(*src, *dst, options...)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to configure sync")
 	}
 
 	defer s.Close()
 
+	if verify {
+		violations, err := s.Verify()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to verify")
+		}
+		subsetter.PrintViolations(os.Stdout, violations)
+		if len(violations) > 0 {
+			s.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *dryRun {
+		plan, err := s.Plan()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to plan sync")
+		}
+		plan.Print(os.Stdout)
+		return
+	}
+
+	// Asked once NewSync made sure the destination is not the source
+	if *truncate && !*yes && !confirm(fmt.Sprintf("Truncate the tables to be copied in %s?", describe(*dst))) {
+		s.Close()
+		log.Fatal().Msg("Truncating cancelled")
+	}
+
 	err = s.Sync()
+	if *reportFile != "" {
+		if err := writeReport(*reportFile, s.Report()); err != nil {
+			log.Error().Err(err).Msg("Failed to write report")
+		}
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to sync")
 	}
 
 }
+
+// usage prints the subcommands and flags.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of subsetter:\n  subsetter [flags]\t\tCopy a subset of the source database to the destination\n  subsetter verify [flags]\tCheck foreign keys of the destination for orphaned rows\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// confirm asks a yes or no question on the terminal, anything but yes is no.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// describe returns the database and server of a DSN, leaving out credentials.
+func describe(dsn string) string {
+	config, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		return "the destination"
+	}
+	return fmt.Sprintf("database %s on %s:%d", config.Database, config.Host, config.Port)
+}
+
+// writeReport writes the report of a sync to a file.
+func writeReport(name string, report subsetter.Report) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = report.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,181 @@
+package subsetter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+// SchemaCheck selects what a sync does when the columns of a table differ between the
+// source and the destination.
+type SchemaCheck string
+
+const (
+	// SchemaAbort stops the sync before copying, listing the differences.
+	SchemaAbort SchemaCheck = "abort"
+	// SchemaIntersect copies only the columns found in both tables, by name.
+	SchemaIntersect SchemaCheck = "intersect"
+)
+
+// SchemaChecks lists the supported schema checks.
+var SchemaChecks = []SchemaCheck{SchemaAbort, SchemaIntersect}
+
+// Column is a column of a table and its type.
+type Column struct {
+	Name string
+	Type string
+}
+
+// GetTableColumns returns the columns of a table in order, a missing table has no columns.
+func GetTableColumns(table string, conn *pgxpool.Pool) (columns []Column, err error) {
+	q := `SELECT attname, format_type(atttypid, atttypmod)
+	FROM   pg_attribute
+	WHERE  attrelid = to_regclass($1)
+	AND    attnum > 0
+	AND    NOT attisdropped
+	ORDER BY attnum;`
+	rows, err := conn.Query(context.Background(), q, QuoteTable(table))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var column Column
+		if err := rows.Scan(&column.Name, &column.Type); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// ColumnDiff lists how the columns of a table differ between source and destination.
+// Common are the columns found in both, in the order of the source.
+type ColumnDiff struct {
+	Table        string
+	MissingTable bool
+	Missing      []string
+	Extra        []string
+	Types        []string
+	Reordered    bool
+	Common       []string
+}
+
+// CompareColumns compares the columns of a table in the source and the destination.
+func CompareColumns(table string, source []Column, destination []Column) ColumnDiff {
+	diff := ColumnDiff{Table: table, MissingTable: len(destination) == 0}
+	names := lo.Map(destination, func(c Column, _ int) string { return c.Name })
+
+	for _, column := range source {
+		i := slices.Index(names, column.Name)
+		if i == -1 {
+			diff.Missing = append(diff.Missing, column.Name)
+			continue
+		}
+		diff.Common = append(diff.Common, column.Name)
+		if destination[i].Type != column.Type {
+			diff.Types = append(diff.Types, fmt.Sprintf("%s is %s in source and %s in destination", column.Name, column.Type, destination[i].Type))
+		}
+	}
+	for _, column := range destination {
+		if !lo.ContainsBy(source, func(c Column) bool { return c.Name == column.Name }) {
+			diff.Extra = append(diff.Extra, column.Name)
+		}
+	}
+	diff.Reordered = !slices.Equal(diff.Common, lo.Filter(names, func(name string, _ int) bool {
+		return slices.Contains(diff.Common, name)
+	}))
+	return diff
+}
+
+// Differs reports whether rows of the table can't be copied with all columns in order.
+func (d *ColumnDiff) Differs() bool {
+	return d.MissingTable || len(d.Missing) > 0 || len(d.Extra) > 0 || len(d.Types) > 0 || d.Reordered
+}
+
+func (d *ColumnDiff) String() string {
+	if d.MissingTable {
+		return fmt.Sprintf("%s: missing in destination", d.Table)
+	}
+	differences := []string{}
+	if len(d.Missing) > 0 {
+		differences = append(differences, "columns missing in destination: "+strings.Join(d.Missing, ", "))
+	}
+	if len(d.Extra) > 0 {
+		differences = append(differences, "columns only in destination: "+strings.Join(d.Extra, ", "))
+	}
+	differences = append(differences, d.Types...)
+	if d.Reordered {
+		differences = append(differences, "columns are in a different order")
+	}
+	return fmt.Sprintf("%s: %s", d.Table, strings.Join(differences, "; "))
+}
+
+// checkSchema compares the columns of tables in the source and the destination. Unless
+// intersecting, differences abort the sync. Otherwise rows of tables that differ are
+// copied with the columns found in both.
+func (s *Sync) checkSchema(tables []Table) error {
+	diffs := []ColumnDiff{}
+	for _, table := range tables {
+		source, err := GetTableColumns(table.FullName(), s.source)
+		if err != nil {
+			return errors.Wrapf(err, "Error getting columns of table %s in source", table.FullName())
+		}
+		destination, err := GetTableColumns(table.FullName(), s.destination)
+		if err != nil {
+			return errors.Wrapf(err, "Error getting columns of table %s in destination", table.FullName())
+		}
+		if diff := CompareColumns(table.FullName(), source, destination); diff.Differs() {
+			diffs = append(diffs, diff)
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+
+	if s.schemaCheck != SchemaIntersect || lo.SomeBy(diffs, func(d ColumnDiff) bool { return len(d.Common) == 0 }) {
+		return errors.Errorf("Destination schema differs from source:\n%s", strings.Join(lo.Map(diffs, func(d ColumnDiff, _ int) string {
+			return d.String()
+		}), "\n"))
+	}
+
+	if s.columns == nil {
+		s.columns = map[string][]string{}
+	}
+	for _, diff := range diffs {
+		log.Warn().Strs("columns", diff.Common).Msgf("Copying common columns, %s", diff.String())
+		s.warn(diff.Table, "Copying common columns, "+diff.String())
+		s.columns[diff.Table] = diff.Common
+	}
+	return nil
+}
+
+// sourceColumns returns the columns of a table in rows copied from the source.
+func (s *Sync) sourceColumns(table string) ([]string, error) {
+	if columns, ok := s.columns[QualifiedName(table)]; ok {
+		return columns, nil
+	}
+	return GetColumns(table, s.source)
+}
+
+// columnsQuery returns the query selecting columns from the rows of a query, all columns without columns.
+func columnsQuery(query string, columns []string) string {
+	if len(columns) == 0 {
+		return query
+	}
+	return fmt.Sprintf(`SELECT %s FROM (%s) AS q`, strings.Join(QuoteColumns(columns), ", "), query)
+}
+
+// copyFromStatement returns the COPY statement loading columns of a quoted table, all columns without columns.
+func copyFromStatement(table string, columns []string) string {
+	if len(columns) == 0 {
+		return fmt.Sprintf(`copy %s from stdin`, table)
+	}
+	return fmt.Sprintf(`copy %s (%s) from stdin`, table, strings.Join(QuoteColumns(columns), ", "))
+}
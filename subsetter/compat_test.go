@@ -0,0 +1,92 @@
+package subsetter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCompareColumns(t *testing.T) {
+	source := []Column{{"id", "integer"}, {"email", "text"}, {"name", "text"}}
+	tests := []struct {
+		name        string
+		destination []Column
+		want        string
+		common      []string
+	}{
+		{"Same", []Column{{"id", "integer"}, {"email", "text"}, {"name", "text"}}, "", []string{"id", "email", "name"}},
+		{"Missing table", nil, "public.users: missing in destination", nil},
+		{
+			"Added and missing",
+			[]Column{{"id", "integer"}, {"email", "text"}, {"age", "integer"}},
+			"public.users: columns missing in destination: name; columns only in destination: age",
+			[]string{"id", "email"},
+		},
+		{
+			"Reordered",
+			[]Column{{"id", "integer"}, {"name", "text"}, {"email", "character varying(100)"}},
+			"public.users: email is text in source and character varying(100) in destination; columns are in a different order",
+			[]string{"id", "email", "name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := CompareColumns("public.users", source, tt.destination)
+			if got := diff.String(); diff.Differs() && got != tt.want {
+				t.Errorf("ColumnDiff.String() = %v, want %v", got, tt.want)
+			}
+			if diff.Differs() != (tt.want != "") {
+				t.Errorf("ColumnDiff.Differs() = %v, want %v", diff.Differs(), tt.want != "")
+			}
+			if !reflect.DeepEqual(diff.Common, tt.common) {
+				t.Errorf("ColumnDiff.Common = %v, want %v", diff.Common, tt.common)
+			}
+		})
+	}
+}
+
+func TestCopyFromStatement(t *testing.T) {
+	if got, want := copyFromStatement(`"public"."users"`, nil), `copy "public"."users" from stdin`; got != want {
+		t.Errorf("copyFromStatement() = %v, want %v", got, want)
+	}
+	if got, want := copyFromStatement(`"public"."users"`, []string{"id", "e-mail"}), `copy "public"."users" ("id", "e-mail") from stdin`; got != want {
+		t.Errorf("copyFromStatement() = %v, want %v", got, want)
+	}
+	if got, want := columnsQuery(`SELECT * FROM "public"."users"`, []string{"id"}), `SELECT "id" FROM (SELECT * FROM "public"."users") AS q`; got != want {
+		t.Errorf("columnsQuery() = %v, want %v", got, want)
+	}
+}
+
+func TestSync_checkSchema(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	initSchema(dst)
+	defer clearSchema(src)
+	defer clearSchema(dst)
+
+	populateTestsWithData(src, "simple", 10)
+	if _, err := dst.Exec(context.Background(), `ALTER TABLE simple ADD COLUMN note text`); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := GetTablesWithRows(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Sync{source: src, destination: dst}
+	if err := s.checkSchema(tables); err == nil {
+		t.Fatal("Sync.checkSchema() didn't abort on a column only in destination")
+	}
+
+	s.schemaCheck = SchemaIntersect
+	if err := s.checkSchema(tables); err != nil {
+		t.Fatalf("Sync.checkSchema() error = %v", err)
+	}
+	if _, err := s.copyQuery(`SELECT * FROM "public"."simple"`, "public.simple"); err != nil {
+		t.Fatalf("Sync.copyQuery() error = %v", err)
+	}
+	if count, _ := CountRows("simple", dst); count != 10 {
+		t.Errorf("CountRows() = %v, want 10", count)
+	}
+}
@@ -1,18 +1,33 @@
 package subsetter
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"strings"
 
-	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 )
 
 // copyTableData copies the data from a table in the source database to the destination database
-func copyTableData(table Table, relatedQueries []string, withLimit bool, source *pgxpool.Pool, destination *pgxpool.Pool) (err error) {
+func (s *Sync) copyTableData(table Table, relatedQueries []string, withLimit bool) (err error) {
+	q := s.tableDataQuery(table, relatedQueries, withLimit)
+	log.Debug().Str("query", q).Msgf("Copying table %s", table.FullName())
+	if _, err = s.copyQuery(q, table.FullName()); err != nil {
+		//log.Error().Err(err).Str("table", table.FullName()).Msg("Error copying table data")
+		return
+	}
+	return
+
+}
+
+// tableDataQuery returns the query selecting rows of a table matching all related queries,
+// limited to the target number of rows of the table. With a seed, limited rows are picked
+// by rowOrder so the same seed and source select the same rows. Large tables without
+// related queries are read with TABLESAMPLE when configured, stratified tables never.
+func (s *Sync) tableDataQuery(table Table, relatedQueries []string, withLimit bool) string {
 	// Backtrace the inserted ids from main table to related table
 	subSelectQuery := ""
 	if len(relatedQueries) > 0 {
@@ -22,120 +37,274 @@ func copyTableData(table Table, relatedQueries []string, withLimit bool, source
 	limit := ""
 	if withLimit {
 		limit = fmt.Sprintf("LIMIT %d", table.Rows)
+		if sample := s.tableSample(table.FullName()); sample.Stratify != "" {
+			return stratifiedQuery(table, subSelectQuery, sample.Stratify, sample.PerGroup, s.seed)
+		}
 	}
 
-	var data string
-	if data, err = CopyTableToString(table.Name, limit, subSelectQuery, source); err != nil {
-		//log.Error().Err(err).Str("table", table.Name).Msg("Error getting table data")
-		return
+	from := QuoteTable(table.FullName())
+	if withLimit && len(relatedQueries) == 0 {
+		if clause := s.tableSampleClause(table); clause != "" {
+			from += " " + clause
+		}
 	}
-	if err = CopyStringToTable(table.Name, data, destination); err != nil {
-		//log.Error().Err(err).Str("table", table.Name).Msg("Error pushing table data")
-		return
+
+	order := ""
+	switch {
+	case s.seed != "" && withLimit:
+		order = "order by " + rowOrder(table.FullName(), s.seed)
+	case subSelectQuery != "":
+		order = "order by random()"
 	}
-	return
+	return fmt.Sprintf(`SELECT * FROM %s %s %s %s`, from, subSelectQuery, order, limit)
+}
 
+// rowOrder returns the expression ordering rows of a table at random. With a seed it is a
+// hash of the seed and the row, a stable pseudo-random order.
+func rowOrder(table string, seed string) string {
+	if seed == "" {
+		return "random()"
+	}
+	return fmt.Sprintf(`md5(%s || CAST(ROW(%s.*) AS text))`, QuoteLiteral(seed), QuoteTable(table))
 }
 
-func relatedQueriesBuilder(
-	depth *int,
-	tables []Table,
-	relation Relation,
-	table Table,
-	source *pgxpool.Pool,
-	destination *pgxpool.Pool,
-	visitedTables *[]string,
-	relatedQueries *[]string,
-) (err error) {
-
-retry:
-	q := fmt.Sprintf(`SELECT %s FROM %s`, relation.ForeignColumn, relation.ForeignTable)
-	log.Debug().Str("query", q).Msgf("Getting keys for %s from target", table.Name)
-
-	if primaryKeys, err := GetKeys(q, destination); err != nil {
-		log.Error().Err(err).Msgf("Error getting keys for %s", table.Name)
-		return err
-	} else {
-		if len(primaryKeys) == 0 {
+// stratifiedQuery returns the query selecting rows of a table spread evenly over the values
+// of a column: rows are ranked within each value and taken rank by rank up to the target,
+// raised to the number of values so every value is copied. With perGroup, perGroup rows of
+// every value are copied instead of the target. Rows are matched by their tableoid and ctid,
+// as the rank is not a column of the table.
+func stratifiedQuery(table Table, where string, column string, perGroup int, seed string) string {
+	name, quoted := QuoteTable(table.FullName()), QuoteIdentifier(column)
+	limit := fmt.Sprintf("ORDER BY n, h LIMIT GREATEST(%d, (SELECT count(*) FROM (SELECT DISTINCT %s FROM %s %s) AS v))", table.Rows, quoted, name, where)
+	if perGroup > 0 {
+		limit = fmt.Sprintf("WHERE n <= %d", perGroup)
+	}
+	order := rowOrder(table.FullName(), seed)
+	return fmt.Sprintf(`SELECT * FROM %[1]s WHERE (tableoid, ctid) IN (SELECT tableoid, ctid FROM (SELECT tableoid, ctid, row_number() OVER (PARTITION BY %[2]s ORDER BY %[3]s) AS n, %[3]s AS h FROM %[1]s %[4]s) AS r %[5]s)`,
+		name, quoted, order, where, limit)
+}
 
-			missingTable := TableByName(tables, relation.ForeignTable)
-			if err = relationalCopy(depth, tables, missingTable, visitedTables, source, destination); err != nil {
-				return errors.Wrapf(err, "Error copying table %s", missingTable.Name)
-			}
+// relatedQueries returns predicates selecting the rows of a table whose foreign keys reference
+// rows already in the destination. References to unfinished tables are skipped.
+func (s *Sync) relatedQueries(table Table, unfinished []string) (relatedQueries []string, err error) {
+	for _, relation := range table.Relations {
+		if relation.IsSelfRelated() || slices.Contains(unfinished, relation.ForeignTable) {
+			continue
+		}
 
-			// Retry short circuit
-			*depth++
+		keys, err := s.parentKeys(table, relation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting keys of %s for %s", relation.ForeignTable, table.FullName())
+		}
+		key, err := GetKey(table.FullName(), relation.PrimaryColumns, s.source)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting key types for %s", table.FullName())
+		}
+		relatedQueries = append(relatedQueries, InPredicate(key, keys))
+	}
+	return
+}
 
-			log.Debug().Int("depth", *depth).Msgf("Retrying keys for %s", relation.ForeignTable)
-			if *depth < 1 {
-				goto retry
-			} else {
-				log.Debug().Str("table", relation.ForeignTable).Str("primary", relation.PrimaryTable).Msgf("No keys found at this time")
-				return errors.New("Max depth reached")
-			}
+// parentKeys returns the keys of the foreign table of a relation, as recorded when copied
+// during this sync or, for other tables, as found in the destination. Either way they are
+// the values of the source.
+func (s *Sync) parentKeys(table Table, relation Relation) ([][]string, error) {
+	if s.isTracked(relation.ForeignTable) {
+		return storedKeys(s.keys, relation.ForeignTable, relation.ForeignColumns)
+	}
 
-		} else {
-			*depth = 0
-			keys := lo.Map(primaryKeys, func(key string, _ int) string {
-				return QuoteString(key)
-			})
-			rq := fmt.Sprintf(`%s IN (%s)`, relation.PrimaryColumn, strings.Join(keys, ","))
-			*relatedQueries = append(*relatedQueries, rq)
+	log.Debug().Str("table", relation.ForeignTable).Msgf("Getting keys for %s from target", table.FullName())
+	return s.unmaskedDestinationKeys(relation.ForeignTable, relation.ForeignColumns, "")
+}
+
+// copyTable copies a fraction of the rows of a table, tables with relations copy all rows
+// referencing rows already in the destination instead. Tables without relations but with
+// include rules only copy the included rows.
+func (s *Sync) copyTable(table Table, unfinished []string) error {
+	if len(table.Relations) == 0 && lo.SomeBy(s.include, func(rule Rule) bool { return rule.Matches(table.FullName()) }) {
+		return nil
+	}
+
+	if len(table.Relations) > 0 {
+		staged := s.keyStaging
+		if !staged {
+			var err error
+			if staged, err = s.manyKeys(table, unfinished); err != nil {
+				return err
+			}
+		}
+		if staged {
+			return s.copyTableStaged(table, unfinished)
 		}
 	}
-	return nil
-}
 
-func relationalCopy(
-	depth *int,
-	tables []Table,
-	table Table,
-	visitedTables *[]string,
-	source *pgxpool.Pool,
-	destination *pgxpool.Pool,
-) error {
-	log.Debug().Str("table", table.Name).Msg("Preparing")
-
-	relatedTables, err := TableGraph(table.Name, table.Relations)
+	relatedQueries, err := s.relatedQueries(table, unfinished)
 	if err != nil {
-		return errors.Wrapf(err, "Error sorting tables from graph")
+		return err
+	}
+	if len(relatedQueries) > 0 {
+		log.Debug().Str("table", table.FullName()).Strs("relatedQueries", relatedQueries).Msg("Transferring related rows")
 	}
-	log.Debug().Strs("tables", relatedTables).Msgf("Order of copy")
 
-	for _, tableName := range relatedTables {
+	if err = s.copyTableData(table, relatedQueries, s.withLimit(table, relatedQueries)); err != nil {
+		return errors.Wrapf(err, "Error copying table %s", table.FullName())
+	}
+	return nil
+}
 
-		if lo.Contains(*visitedTables, tableName) {
+// keyStagingThreshold is the number of keys referenced by a relation above which they are
+// staged in a temporary table even without WithKeyStaging, keeping queries small.
+const keyStagingThreshold = 10000
+
+// manyKeys reports whether a relation of a table references more keys than are sent in a query,
+// counted in the key store or, for tables not copied during this sync, in the destination.
+func (s *Sync) manyKeys(table Table, unfinished []string) (bool, error) {
+	for _, relation := range table.Relations {
+		if relation.IsSelfRelated() || slices.Contains(unfinished, relation.ForeignTable) {
 			continue
 		}
 
-		relatedTable := TableByName(tables, tableName)
-		*visitedTables = append(*visitedTables, relatedTable.Name)
-		// Use realized query to get primary keys that are already in the destination for all related tables
+		var count int
+		var err error
+		if s.isTracked(relation.ForeignTable) {
+			count, err = s.keys.Count(relation.ForeignTable, relation.ForeignColumns)
+		} else {
+			count, err = CountRows(relation.ForeignTable, s.destination)
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "Error counting keys of %s for %s", relation.ForeignTable, table.FullName())
+		}
+		if count > keyStagingThreshold {
+			log.Debug().Int("keys", count).Str("table", relation.ForeignTable).Msgf("Staging keys for %s", table.FullName())
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-		// Selection query for this table
-		relatedQueries := []string{}
+// copyIncluded copies the rows of a table matching include rules. Tables with relations
+// copy them with user triggers disabled.
+func (s *Sync) copyIncluded(table Table) (err error) {
+	for _, include := range s.include {
+		if !include.Matches(table.FullName()) {
+			continue
+		}
+		include := Rule{Table: table.FullName(), Where: include.Where}
 
-		for _, relation := range relatedTable.Relations {
-			err := relatedQueriesBuilder(depth, tables, relation, relatedTable, source, destination, visitedTables, &relatedQueries)
-			if err != nil {
-				return err
+		if len(table.Relations) == 0 {
+			if err = include.Copy(s); err != nil {
+				return errors.Wrapf(err, "Error copying forced rows for table %s", table.FullName())
 			}
+			continue
+		}
+
+		// Copy only primary row by first setting ignore relational checks
+		if _, err = s.destination.Exec(context.Background(), fmt.Sprintf("ALTER TABLE %s DISABLE TRIGGER USER;", table.QuotedName())); err != nil {
+			return errors.Wrap(err, "Error setting session_replication_role to replica")
 		}
 
-		if len(relatedQueries) > 0 {
-			log.Debug().Str("table", relatedTable.Name).Strs("relatedQueries", relatedQueries).Msg("Transferring with relationalCopy")
+		if err = include.Copy(s); err != nil {
+			return errors.Wrapf(err, "Error copying forced rows for table %s", table.FullName())
 		}
 
-		if err = copyTableData(relatedTable, relatedQueries, false, source, destination); err != nil {
-			if condition, ok := err.(*pgconn.PgError); ok && condition.Code == "23503" { // foreign key violation
-				if err := relationalCopy(depth, tables, relatedTable, visitedTables, source, destination); err != nil {
-					return errors.Wrapf(err, "Error copying table %s", relatedTable.Name)
+		// Set relational checks back
+		if _, err = s.destination.Exec(context.Background(), fmt.Sprintf("ALTER TABLE %s ENABLE TRIGGER USER;", table.QuotedName())); err != nil {
+			return errors.Wrap(err, "Error setting session_replication_role to origin")
+		}
+	}
+	return nil
+}
+
+// requiredStep is a table referencing rows required by an include rule, directly or through
+// other steps, and its relations to the tables of earlier steps.
+type requiredStep struct {
+	table     Table
+	relations []Relation
+}
+
+// requiredSteps returns the tables referencing rows of the primary table, directly or through
+// other tables, in topological order: the tables a step references come before it.
+func requiredSteps(tables []Table, primary string) (steps []requiredStep, err error) {
+	relations := lo.FlatMap(tables, func(table Table, _ int) []Relation { return table.Relations })
+	order, err := RequiredTableGraph(primary, relations)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error sorting tables referencing %s", primary)
+	}
+
+	required := map[string]bool{primary: true}
+	for _, name := range order {
+		table := TableByName(tables, name)
+		if required[name] || table.FullName() == "" { // skip self and unresolvable tables
+			continue
+		}
+		references := lo.Filter(table.Relations, func(relation Relation, _ int) bool {
+			return !relation.IsSelfRelated() && required[relation.ForeignTable]
+		})
+		if len(references) > 0 {
+			required[name] = true
+			steps = append(steps, requiredStep{table, references})
+		}
+	}
+	return
+}
+
+// copyRequired copies the rows of other tables that reference rows included in tables without
+// relations, following references through any number of tables. Each step copies the rows
+// referencing the keys of rows selected by the steps before it.
+func (s *Sync) copyRequired(tables []Table) {
+	for _, table := range tables {
+		if len(table.Relations) > 0 {
+			continue
+		}
+		for _, include := range s.include {
+			if !include.Matches(table.FullName()) || include.Where == RuleAll {
+				continue
+			}
+
+			steps, err := requiredSteps(tables, table.FullName())
+			if err != nil {
+				log.Warn().Err(err).Str("table", table.FullName()).Msg("Skipping rows referencing included rows")
+				s.warn(table.FullName(), err.Error())
+				continue
+			}
+			selected := map[string]string{table.FullName(): include.Where}
+			for _, step := range steps {
+				where, err := s.referencingPredicate(step, selected)
+				if err == nil && where != "" {
+					selected[step.table.FullName()] = where
+					err = (&Rule{Table: step.table.FullName(), Where: where}).Copy(s)
+				}
+				if err != nil {
+					log.Warn().Err(err).Str("table", step.table.FullName()).Msgf("Error copying rows referencing included rows")
+					s.warn(step.table.FullName(), err.Error())
 				}
 			}
-			return errors.Wrapf(err, "Error copying table %s", relatedTable.Name)
 		}
-
 	}
+}
 
-	return nil
+// referencingPredicate returns the predicate selecting rows of a step referencing the rows
+// selected in earlier steps, empty when no rows are referenced. Keys of selected rows are
+// read from the source.
+func (s *Sync) referencingPredicate(step requiredStep, selected map[string]string) (string, error) {
+	predicates := []string{}
+	for _, relation := range step.relations {
+		parent := Rule{Table: relation.ForeignTable, Where: selected[relation.ForeignTable]}
+		keys, err := GetKeys(parent.includedKeysQuery(relation), s.source)
+		if err != nil {
+			return "", errors.Wrapf(err, "Error getting keys of %s for %s", relation.ForeignTable, step.table.FullName())
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		types, err := GetColumnTypes(step.table.FullName(), relation.PrimaryColumns, s.source)
+		if err != nil {
+			return "", errors.Wrapf(err, "Error getting key types for table %s", step.table.FullName())
+		}
+		predicates = append(predicates, InPredicate(Key{QuoteColumns(relation.PrimaryColumns), types}, keys))
+	}
+	if len(predicates) == 0 {
+		return "", nil
+	}
+	return "(" + strings.Join(predicates, " OR ") + ")", nil
 }
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samber/lo"
 )
 
 var testConnSrc *pgxpool.Pool
@@ -96,3 +98,9 @@ func clearSchema(conn *pgxpool.Pool) {
 		panic(err)
 	}
 }
+
+// swapHex masks UUIDs by swapping their hex digits, masked values are still unique UUIDs.
+func swapHex(seed []byte, value string) *string {
+	return lo.ToPtr(strings.NewReplacer("0", "f", "1", "e", "2", "d", "3", "c", "4", "b", "5", "a", "6", "9", "7", "8",
+		"8", "7", "9", "6", "a", "5", "b", "4", "c", "3", "d", "2", "e", "1", "f", "0").Replace(value))
+}
@@ -1,6 +1,7 @@
 package subsetter
 
 import (
+	"slices"
 	"testing"
 
 	"github.com/samber/lo"
@@ -9,15 +10,15 @@ import (
 func TestTableGraph(t *testing.T) {
 
 	relations := []Relation{
-		{"blog_networks", "id", "blogs", "blog_id"},
-		{"users", "id", "blog_networks", "user_id"},
-		{"users", "id", "blogs", "user_id"},
-		{"users", "id", "users", "owner_id"}, // self reference
-		{"users", "id", "collaborator_api_keys", "user_id"},
-		{"blogs", "id", "backups", "blog_id"},
-		{"blogs", "id", "blog_imports", "blog_id"},
-		{"blogs", "id", "blog_imports", "blog_id"},
-		{"blogs", "id", "cleanup_notification", "blog_id"},
+		{"blog_networks", []string{"id"}, "blogs", []string{"blog_id"}},
+		{"users", []string{"id"}, "blog_networks", []string{"user_id"}},
+		{"users", []string{"id"}, "blogs", []string{"user_id"}},
+		{"users", []string{"id"}, "users", []string{"owner_id"}}, // self reference
+		{"users", []string{"id"}, "collaborator_api_keys", []string{"user_id"}},
+		{"blogs", []string{"id"}, "backups", []string{"blog_id"}},
+		{"blogs", []string{"id"}, "blog_imports", []string{"blog_id"}},
+		{"blogs", []string{"id"}, "blog_imports", []string{"blog_id"}},
+		{"blogs", []string{"id"}, "cleanup_notification", []string{"blog_id"}},
 	}
 
 	got, _ := TableGraph("users", relations)
@@ -30,15 +31,15 @@ func TestTableGraph(t *testing.T) {
 func TestTableGraphNnoRelation(t *testing.T) {
 
 	relations := []Relation{
-		{"blog_networks", "id", "blogs", "blog_id"},
-		{"users", "id", "blog_networks", "user_id"},
-		{"users", "id", "blogs", "user_id"},
-		{"users", "id", "users", "owner_id"}, // self reference
-		{"users", "id", "collaborator_api_keys", "user_id"},
-		{"blogs", "id", "backups", "blog_id"},
-		{"blogs", "id", "blog_imports", "blog_id"},
-		{"blogs", "id", "blog_imports", "blog_id"},
-		{"blogs", "id", "cleanup_notification", "blog_id"},
+		{"blog_networks", []string{"id"}, "blogs", []string{"blog_id"}},
+		{"users", []string{"id"}, "blog_networks", []string{"user_id"}},
+		{"users", []string{"id"}, "blogs", []string{"user_id"}},
+		{"users", []string{"id"}, "users", []string{"owner_id"}}, // self reference
+		{"users", []string{"id"}, "collaborator_api_keys", []string{"user_id"}},
+		{"blogs", []string{"id"}, "backups", []string{"blog_id"}},
+		{"blogs", []string{"id"}, "blog_imports", []string{"blog_id"}},
+		{"blogs", []string{"id"}, "blog_imports", []string{"blog_id"}},
+		{"blogs", []string{"id"}, "cleanup_notification", []string{"blog_id"}},
 	}
 
 	got, _ := TableGraph("simple", relations)
@@ -48,3 +49,29 @@ func TestTableGraphNnoRelation(t *testing.T) {
 	}
 
 }
+
+func TestRequiredSteps(t *testing.T) {
+	tables := []Table{
+		{"public", "users", 10, nil, nil},
+		{"public", "orders", 10, []Relation{{"public.orders", []string{"user_id"}, "public.users", []string{"id"}}}, nil},
+		{"public", "items", 10, []Relation{{"public.items", []string{"order_id"}, "public.orders", []string{"id"}}}, nil},
+		{"public", "notes", 10, []Relation{
+			{"public.notes", []string{"item_id"}, "public.items", []string{"id"}},
+			{"public.notes", []string{"user_id"}, "public.users", []string{"id"}},
+			{"public.notes", []string{"plan_id"}, "public.plans", []string{"id"}},
+		}, nil},
+		{"public", "plans", 10, nil, nil},
+	}
+
+	steps, err := requiredSteps(tables, "public.users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := lo.Map(steps, func(step requiredStep, _ int) string { return step.table.FullName() })
+	if want := []string{"public.orders", "public.items", "public.notes"}; !slices.Equal(got, want) {
+		t.Fatalf("requiredSteps() = %v, want %v", got, want)
+	}
+	if len(steps[2].relations) != 2 {
+		t.Errorf("requiredSteps() notes relations = %v, want relations to items and users", steps[2].relations)
+	}
+}
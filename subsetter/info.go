@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 
 	"github.com/samber/lo"
 )
@@ -16,10 +17,11 @@ func GetTargetSet(fraction float64, tables []Table) []Table {
 	})
 }
 
+// QuoteString quotes a string literal for use in SQL, numbers are returned as is.
 func QuoteString(s string) string {
 	// if string is a number, don't quote it
 	if _, err := strconv.Atoi(s); err == nil {
 		return s
 	}
-	return fmt.Sprintf(`'%s'`, s)
+	return fmt.Sprintf(`'%s'`, strings.ReplaceAll(s, "'", "''"))
 }
@@ -16,11 +16,11 @@ func TestGetTargetSet(t *testing.T) {
 		want     []Table
 	}{
 		{"simple", 0.5,
-			[]Table{{"simple", 1000, []Relation{}, []Relation{}}},
-			[]Table{{"simple", 31, []Relation{}, []Relation{}}}},
+			[]Table{{"public", "simple", 1000, []Relation{}, []Relation{}}},
+			[]Table{{"public", "simple", 31, []Relation{}, []Relation{}}}},
 		{"simple", 0.5,
-			[]Table{{"simple", 10, []Relation{}, []Relation{}}},
-			[]Table{{"simple", 3, []Relation{}, []Relation{}}}},
+			[]Table{{"public", "simple", 10, []Relation{}, []Relation{}}},
+			[]Table{{"public", "simple", 3, []Relation{}, []Relation{}}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -30,3 +30,22 @@ func TestGetTargetSet(t *testing.T) {
 		})
 	}
 }
+
+func TestQuoteString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"Number", "42", "42"},
+		{"Text", "abc", "'abc'"},
+		{"Quotes", "O'Brien", "'O''Brien'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuoteString(tt.s); got != tt.want {
+				t.Errorf("QuoteString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
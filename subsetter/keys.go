@@ -0,0 +1,69 @@
+package subsetter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samber/lo"
+)
+
+// Key holds the quoted expressions identifying rows of a table with their SQL types,
+// keys are compared as typed arrays so values keep their type.
+type Key struct {
+	Columns []string
+	Types   []string
+}
+
+// GetKey returns the key of columns of a table with their types. Without columns, rows
+// are identified by a hash of the whole row, see RowKey.
+func GetKey(table string, columns []string, conn *pgxpool.Pool) (key Key, err error) {
+	key.Columns = RowKey(table, columns)
+	if len(columns) == 0 {
+		key.Types = []string{"text"}
+		return
+	}
+	key.Types, err = GetColumnTypes(table, columns, conn)
+	return
+}
+
+// GetColumnTypes returns the SQL types of columns of a table, in the order of the columns.
+func GetColumnTypes(table string, columns []string, conn *pgxpool.Pool) (types []string, err error) {
+	q := `SELECT format_type(a.atttypid, a.atttypmod)
+	FROM   unnest($2::text[]) WITH ORDINALITY AS c(name, position)
+	JOIN   pg_attribute a ON a.attrelid = $1::regclass AND a.attname = c.name
+	ORDER BY c.position;`
+	rows, err := conn.Query(context.Background(), q, QuoteTable(table), columns)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	if err = rows.Err(); err == nil && len(types) != len(columns) {
+		err = fmt.Errorf("columns %s not found in table %s", strings.Join(columns, ", "), table)
+	}
+	return
+}
+
+// arrayLiteral returns values as a typed array, e.g. CAST('{"1","2"}' AS integer[]).
+// Values are the text representation of the type.
+func arrayLiteral(values []string, typ string) string {
+	elements := lo.Map(values, func(value string, _ int) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	})
+	return fmt.Sprintf("CAST(%s AS %s[])", QuoteString("{"+strings.Join(elements, ",")+"}"), typ)
+}
+
+// keyArrays returns one typed array per column of the key, holding the values of that column.
+func keyArrays(key Key, keys [][]string) []string {
+	return lo.Map(key.Types, func(typ string, i int) string {
+		return arrayLiteral(lo.Map(keys, func(k []string, _ int) string { return k[i] }), typ)
+	})
+}
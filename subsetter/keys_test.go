@@ -0,0 +1,33 @@
+package subsetter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGetKey(t *testing.T) {
+	conn := getTestConnection()
+	initSchema(conn)
+	defer clearSchema(conn)
+
+	key, err := GetKey("simple", []string{"id", "text"}, conn)
+	if err != nil {
+		t.Fatalf("GetKey() error = %v", err)
+	}
+	if want := (Key{[]string{`"id"`, `"text"`}, []string{"uuid", "text"}}); !reflect.DeepEqual(key, want) {
+		t.Errorf("GetKey() = %v, want %v", key, want)
+	}
+
+	if _, err := conn.Exec(context.Background(), `INSERT INTO simple (text) VALUES ('O''Brien "Bob"'), ('007'), ('7')`); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := GetKeys(keysQuery("simple", key.Columns)+" WHERE text <> '7'", conn)
+	if err != nil {
+		t.Fatalf("GetKeys() error = %v", err)
+	}
+	var count int
+	if err := conn.QueryRow(context.Background(), "SELECT count(*) FROM simple WHERE "+InPredicate(key, keys)).Scan(&count); err != nil || count != 2 {
+		t.Errorf("InPredicate() matched %v rows, %v, want 2", count, err)
+	}
+}
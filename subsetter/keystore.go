@@ -0,0 +1,355 @@
+package subsetter
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// KeyStore records the keys of rows copied into tables during a sync, keys are
+// the text values of columns referenced by foreign keys.
+type KeyStore interface {
+	// Add records keys of columns of a table, keys already recorded are ignored.
+	Add(table string, columns []string, keys [][]string) error
+	// Each calls f for every recorded key of columns of a table.
+	Each(table string, columns []string, f func(key []string) error) error
+	// Count returns the number of recorded keys of columns of a table.
+	Count(table string, columns []string) (int, error)
+	// Remove forgets all keys recorded for a table.
+	Remove(table string) error
+	Close() error
+}
+
+// keySeparator separates values in encoded keys, text values never contain it.
+const keySeparator = "\x00"
+
+// keySetName returns the name under which keys of columns of a table are stored.
+func keySetName(table string, columns []string) string {
+	return QualifiedName(table) + keySeparator + strings.Join(columns, keySeparator)
+}
+
+// memoryKeyStore keeps keys in memory.
+type memoryKeyStore struct {
+	mutex sync.Mutex
+	sets  map[string]map[string]struct{}
+}
+
+// NewMemoryKeyStore returns a key store keeping keys in memory.
+func NewMemoryKeyStore() KeyStore {
+	return &memoryKeyStore{sets: map[string]map[string]struct{}{}}
+}
+
+func (ks *memoryKeyStore) Add(table string, columns []string, keys [][]string) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	name := keySetName(table, columns)
+	if ks.sets[name] == nil {
+		ks.sets[name] = map[string]struct{}{}
+	}
+	for _, key := range keys {
+		ks.sets[name][strings.Join(key, keySeparator)] = struct{}{}
+	}
+	return nil
+}
+
+func (ks *memoryKeyStore) Each(table string, columns []string, f func(key []string) error) error {
+	ks.mutex.Lock()
+	keys := lo.Keys(ks.sets[keySetName(table, columns)])
+	ks.mutex.Unlock()
+
+	for _, key := range keys {
+		if err := f(strings.Split(key, keySeparator)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ks *memoryKeyStore) Count(table string, columns []string) (int, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	return len(ks.sets[keySetName(table, columns)]), nil
+}
+
+func (ks *memoryKeyStore) Remove(table string) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	for name := range ks.sets {
+		if strings.HasPrefix(name, QualifiedName(table)+keySeparator) {
+			delete(ks.sets, name)
+		}
+	}
+	return nil
+}
+
+func (ks *memoryKeyStore) Close() error {
+	return nil
+}
+
+// boltKeyStore keeps keys in an embedded database file, so they don't have to fit in memory.
+type boltKeyStore struct {
+	db *bolt.DB
+}
+
+// OpenKeyStore opens a key store spilling keys to a file. Keys recorded by earlier syncs
+// using the same file are cleared, they may not be in the destination anymore.
+func OpenKeyStore(path string) (KeyStore, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening key store %s", path)
+	}
+	if err = clearBuckets(db); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "Error clearing key store %s", path)
+	}
+	return &boltKeyStore{db: db}, nil
+}
+
+// clearBuckets deletes all buckets of a database.
+func clearBuckets(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		names := [][]byte{}
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ks *boltKeyStore) Add(table string, columns []string, keys [][]string) error {
+	return ks.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(keySetName(table, columns)))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := bucket.Put([]byte(strings.Join(key, keySeparator)), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ks *boltKeyStore) Each(table string, columns []string, f func(key []string) error) error {
+	return ks.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(keySetName(table, columns)))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, _ []byte) error {
+			return f(strings.Split(string(k), keySeparator))
+		})
+	})
+}
+
+func (ks *boltKeyStore) Count(table string, columns []string) (count int, err error) {
+	err = ks.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(keySetName(table, columns))); bucket != nil {
+			count = bucket.Stats().KeyN
+		}
+		return nil
+	})
+	return
+}
+
+func (ks *boltKeyStore) Remove(table string) error {
+	return ks.db.Update(func(tx *bolt.Tx) error {
+		prefix := []byte(QualifiedName(table) + keySeparator)
+		names := [][]byte{}
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if bytes.HasPrefix(name, prefix) {
+				names = append(names, append([]byte{}, name...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ks *boltKeyStore) Close() error {
+	return ks.db.Close()
+}
+
+// storedKeys returns all recorded keys of columns of a table.
+func storedKeys(store KeyStore, table string, columns []string) (keys [][]string, err error) {
+	err = store.Each(table, columns, func(key []string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return
+}
+
+// keyColumns are the columns of a table recorded in a key store and their positions.
+type keyColumns struct {
+	columns []string
+	indexes []int
+}
+
+// keyBatchSize is the number of rows whose keys are held by a recorder before they
+// are added to the key store.
+var keyBatchSize = 10000
+
+// keyRecorder collects keys of rows in the COPY text format written through it,
+// holding back incomplete rows until their line ending arrives. Keys are added to
+// the store in batches, so a copy never holds more than a batch in memory.
+type keyRecorder struct {
+	w       io.Writer
+	store   KeyStore
+	table   string
+	sets    []keyColumns
+	keys    [][][]string
+	rows    int
+	pending []byte
+}
+
+func newKeyRecorder(store KeyStore, table string, sets []keyColumns) *keyRecorder {
+	return &keyRecorder{store: store, table: table, sets: sets, keys: make([][][]string, len(sets))}
+}
+
+func (kr *keyRecorder) Write(p []byte) (int, error) {
+	n, err := kr.w.Write(p)
+	kr.pending = append(kr.pending, p[:n]...)
+	for {
+		i := bytes.IndexByte(kr.pending, '\n')
+		if i < 0 {
+			break
+		}
+		kr.row(string(kr.pending[:i]))
+		kr.pending = kr.pending[i+1:]
+	}
+	kr.pending = append([]byte{}, kr.pending...)
+	if err == nil && kr.rows >= keyBatchSize {
+		err = kr.flush()
+	}
+	return n, err
+}
+
+// row records the keys of a single row, keys with a NULL value are not recorded.
+func (kr *keyRecorder) row(line string) {
+	fields := strings.Split(line, "\t")
+	for i, set := range kr.sets {
+		key := make([]string, 0, len(set.indexes))
+		for _, index := range set.indexes {
+			if index >= len(fields) {
+				break
+			}
+			value := decodeCopyField(fields[index])
+			if value == nil {
+				break
+			}
+			key = append(key, *value)
+		}
+		if len(key) == len(set.indexes) {
+			kr.keys[i] = append(kr.keys[i], key)
+		}
+	}
+	kr.rows++
+}
+
+// flush adds the collected keys to the store.
+func (kr *keyRecorder) flush() error {
+	for i, set := range kr.sets {
+		if err := kr.store.Add(kr.table, set.columns, kr.keys[i]); err != nil {
+			return errors.Wrapf(err, "Error recording keys of %s", kr.table)
+		}
+		kr.keys[i] = nil
+	}
+	kr.rows = 0
+	return nil
+}
+
+// keysReader returns recorded keys in the COPY text format.
+func keysReader(store KeyStore, table string, columns []string) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(store.Each(table, columns, func(key []string) error {
+			_, err := io.WriteString(writer, strings.Join(lo.Map(key, func(value string, _ int) string {
+				return encodeCopyField(&value)
+			}), "\t")+"\n")
+			return err
+		}))
+	}()
+	return reader
+}
+
+// isTracked reports whether the keys of rows copied into a table are recorded during this sync.
+func (s *Sync) isTracked(table string) bool {
+	s.trackedMutex.RLock()
+	defer s.trackedMutex.RUnlock()
+	return s.keys != nil && s.tracked[QualifiedName(table)]
+}
+
+// track records the keys of rows copied into tables from now on.
+func (s *Sync) track(tables []Table) {
+	s.trackedMutex.Lock()
+	defer s.trackedMutex.Unlock()
+	if s.tracked == nil {
+		s.tracked = map[string]bool{}
+	}
+	for _, table := range tables {
+		s.tracked[table.FullName()] = true
+	}
+}
+
+// keyRecorder returns a recorder for the columns of a table referenced by foreign keys,
+// nil when keys are not recorded.
+func (s *Sync) keyRecorder(table string) (*keyRecorder, error) {
+	if !s.isTracked(table) {
+		return nil, nil
+	}
+
+	sets := []keyColumns{}
+	var columns []string
+	for _, relation := range GetRequiredBy(QualifiedName(table), s.source) {
+		if lo.ContainsBy(sets, func(set keyColumns) bool { return slices.Equal(set.columns, relation.ForeignColumns) }) {
+			continue
+		}
+		if columns == nil {
+			var err error
+			if columns, err = s.sourceColumns(table); err != nil {
+				return nil, errors.Wrapf(err, "Error getting columns for table %s", table)
+			}
+		}
+		indexes := lo.Map(relation.ForeignColumns, func(column string, _ int) int { return slices.Index(columns, column) })
+		if slices.Contains(indexes, -1) {
+			continue
+		}
+		sets = append(sets, keyColumns{columns: relation.ForeignColumns, indexes: indexes})
+	}
+	if len(sets) == 0 {
+		return nil, nil
+	}
+	return newKeyRecorder(s.keys, table, sets), nil
+}
+
+// saveKeys adds the keys still held by a recorder to the key store.
+func (s *Sync) saveKeys(rec *keyRecorder) error {
+	if rec == nil {
+		return nil
+	}
+	return rec.flush()
+}
@@ -0,0 +1,139 @@
+package subsetter
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+	bolt, err := OpenKeyStore(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]KeyStore{"Memory": NewMemoryKeyStore(), "Bolt": bolt}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			if err := store.Add("users", []string{"id"}, [][]string{{"1"}, {"2"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Add("public.users", []string{"id"}, [][]string{{"2"}, {"3"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Add("users", []string{"id", "tenant"}, [][]string{{"1", "a\tb"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := storedKeys(store, "users", []string{"id"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.SortFunc(keys, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+			if want := [][]string{{"1"}, {"2"}, {"3"}}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("storedKeys() = %v, want %v", keys, want)
+			}
+
+			b, _ := io.ReadAll(keysReader(store, "users", []string{"id", "tenant"}))
+			if want := "1\ta\\tb\n"; string(b) != want {
+				t.Errorf("keysReader() = %q, want %q", b, want)
+			}
+
+			if keys, _ := storedKeys(store, "orders", []string{"id"}); len(keys) != 0 {
+				t.Errorf("storedKeys() = %v, want no keys", keys)
+			}
+			if count, _ := store.Count("users", []string{"id"}); count != 3 {
+				t.Errorf("Count() = %v, want 3", count)
+			}
+			if count, _ := store.Count("orders", []string{"id"}); count != 0 {
+				t.Errorf("Count() = %v, want 0", count)
+			}
+
+			if err := store.Remove("public.users"); err != nil {
+				t.Fatal(err)
+			}
+			if keys, _ := storedKeys(store, "users", []string{"id", "tenant"}); len(keys) != 0 {
+				t.Errorf("storedKeys() = %v, want no keys after Remove()", keys)
+			}
+		})
+	}
+}
+
+func TestKeyRecorder(t *testing.T) {
+	store := NewMemoryKeyStore()
+	rec := newKeyRecorder(store, "public.items", []keyColumns{
+		{columns: []string{"id"}, indexes: []int{0}},
+		{columns: []string{"tenant", "code"}, indexes: []int{2, 1}},
+	})
+	var out strings.Builder
+	rec.w = &out
+
+	data := "1\tx\\ty\tacme\n2\t\\N\tacme\n"
+	for _, chunk := range []string{data[:5], data[5:]} {
+		if _, err := rec.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out.String() != data {
+		t.Errorf("keyRecorder passed %q, want %q", out.String(), data)
+	}
+	want := [][][]string{{{"1"}, {"2"}}, {{"acme", "x\ty"}}}
+	if !reflect.DeepEqual(rec.keys, want) {
+		t.Errorf("keyRecorder recorded %v, want %v", rec.keys, want)
+	}
+
+	if err := rec.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := storedKeys(store, "public.items", []string{"tenant", "code"}); !reflect.DeepEqual(keys, want[1]) {
+		t.Errorf("storedKeys() = %v, want %v", keys, want[1])
+	}
+	if !reflect.DeepEqual(rec.keys, [][][]string{nil, nil}) {
+		t.Errorf("keyRecorder kept %v after flushing", rec.keys)
+	}
+}
+
+func TestKeyRecorder_batches(t *testing.T) {
+	defer func(size int) { keyBatchSize = size }(keyBatchSize)
+	keyBatchSize = 2
+
+	store := NewMemoryKeyStore()
+	rec := newKeyRecorder(store, "public.items", []keyColumns{{columns: []string{"id"}, indexes: []int{0}}})
+	rec.w = io.Discard
+	if _, err := rec.Write([]byte("1\n2\n3\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ := storedKeys(store, "public.items", []string{"id"})
+	slices.SortFunc(keys, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+	if want := [][]string{{"1"}, {"2"}, {"3"}}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("storedKeys() = %v, want %v", keys, want)
+	}
+}
+
+func TestOpenKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+	store, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("users", []string{"id"}, [][]string{{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if keys, _ := storedKeys(store, "users", []string{"id"}); len(keys) != 0 {
+		t.Errorf("OpenKeyStore() kept %v, want keys of earlier syncs cleared", keys)
+	}
+}
@@ -0,0 +1,99 @@
+package subsetter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// LoadMode selects how copied rows are written into destination tables.
+type LoadMode string
+
+const (
+	// LoadCopy copies rows straight into tables, failing on rows that already exist.
+	LoadCopy LoadMode = "copy"
+	// LoadSkip skips rows that already exist.
+	LoadSkip LoadMode = "skip"
+	// LoadUpdate updates rows that already exist.
+	LoadUpdate LoadMode = "update"
+)
+
+// LoadModes lists the supported load modes.
+var LoadModes = []LoadMode{LoadCopy, LoadSkip, LoadUpdate}
+
+// loadStaging is the temporary table rows are copied into before being loaded into a table.
+var loadStaging = pgx.Identifier{"pg_temp", "subsetter_load"}.Sanitize()
+
+// LoadQuery returns the query inserting columns of rows from a staging table into a table.
+// Rows that conflict with existing rows are skipped or, with LoadUpdate, updated using the
+// key. Tables without a key skip rows equal to an existing row in these columns. Values of
+// identity columns are kept, as COPY does.
+func LoadQuery(table string, staging string, mode LoadMode, key []string, columns []string) string {
+	list := strings.Join(QuoteColumns(columns), ", ")
+	if len(key) == 0 {
+		return fmt.Sprintf(`INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE SELECT %s FROM %s AS s WHERE NOT EXISTS (SELECT 1 FROM %s AS d WHERE md5(CAST(ROW(%s) AS text)) = md5(CAST(ROW(%s) AS text)))`,
+			QuoteTable(table), list, list, staging, QuoteTable(table), strings.Join(qualifyColumns("d", columns), ", "), strings.Join(qualifyColumns("s", columns), ", "))
+	}
+
+	q := fmt.Sprintf(`INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE SELECT %s FROM %s ON CONFLICT`, QuoteTable(table), list, list, staging)
+	updates := lo.Without(columns, key...)
+	if mode != LoadUpdate || len(updates) == 0 {
+		return q + " DO NOTHING"
+	}
+	return fmt.Sprintf(`%s (%s) DO UPDATE SET %s`, q, strings.Join(QuoteColumns(key), ", "), strings.Join(lo.Map(updates, func(column string, _ int) string {
+		return fmt.Sprintf("%s = EXCLUDED.%s", QuoteIdentifier(column), QuoteIdentifier(column))
+	}), ", "))
+}
+
+// loadQuery returns the query loading columns of staged rows into a table of the
+// destination, all columns without columns.
+func loadQuery(table string, mode LoadMode, columns []string, destination *pgxpool.Pool) (string, error) {
+	key, err := GetRowKey(table, destination)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error getting primary key for table %s", table)
+	}
+	if len(columns) == 0 {
+		if columns, err = GetColumns(table, destination); err != nil {
+			return "", errors.Wrapf(err, "Error getting columns for table %s", table)
+		}
+	}
+	return LoadQuery(table, loadStaging, mode, key, columns), nil
+}
+
+// stagingTableQuery returns the query creating the staging table with the copied columns of
+// a table, all columns without columns.
+func stagingTableQuery(table string, columns []string) string {
+	list := "*"
+	if len(columns) > 0 {
+		list = strings.Join(QuoteColumns(columns), ", ")
+	}
+	return fmt.Sprintf("CREATE TEMP TABLE %s AS SELECT %s FROM %s WITH NO DATA", loadStaging, list, QuoteTable(table))
+}
+
+// stagedLoad copies columns of rows into a temporary table of a destination connection and
+// loads them into a table with the load query, returning the number of loaded rows. The
+// temporary table only has the copied columns, without constraints, so columns left out
+// get their defaults from the table when loading.
+func stagedLoad(dst *pgxpool.Conn, table string, columns []string, load string, copyInto func(copyFrom string) error) (int64, error) {
+	ctx := context.Background()
+	if _, err := dst.Exec(ctx, stagingTableQuery(table, columns)); err != nil {
+		return 0, errors.Wrapf(err, "Error creating staging table for %s", table)
+	}
+	defer func() {
+		_, _ = dst.Exec(ctx, "DROP TABLE IF EXISTS "+loadStaging)
+	}()
+
+	if err := copyInto(copyFromStatement(loadStaging, columns)); err != nil {
+		return 0, err
+	}
+	tag, err := dst.Exec(ctx, load)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Error loading rows into %s", table)
+	}
+	return tag.RowsAffected(), nil
+}
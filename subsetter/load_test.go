@@ -0,0 +1,96 @@
+package subsetter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestLoadQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    LoadMode
+		key     []string
+		columns []string
+		want    string
+	}{
+		{"Skip", LoadSkip, []string{"id"}, []string{"id", "text"}, `INSERT INTO "public"."simple" ("id", "text") OVERRIDING SYSTEM VALUE SELECT "id", "text" FROM staging ON CONFLICT DO NOTHING`},
+		{"Update", LoadUpdate, []string{"id"}, []string{"id", "text"}, `INSERT INTO "public"."simple" ("id", "text") OVERRIDING SYSTEM VALUE SELECT "id", "text" FROM staging ON CONFLICT ("id") DO UPDATE SET "text" = EXCLUDED."text"`},
+		{"Update key only", LoadUpdate, []string{"id"}, []string{"id"}, `INSERT INTO "public"."simple" ("id") OVERRIDING SYSTEM VALUE SELECT "id" FROM staging ON CONFLICT DO NOTHING`},
+		{
+			"Without key",
+			LoadSkip,
+			nil,
+			[]string{"id", "text"},
+			`INSERT INTO "public"."simple" ("id", "text") OVERRIDING SYSTEM VALUE SELECT "id", "text" FROM staging AS s WHERE NOT EXISTS (SELECT 1 FROM "public"."simple" AS d WHERE md5(CAST(ROW(d."id", d."text") AS text)) = md5(CAST(ROW(s."id", s."text") AS text)))`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LoadQuery("public.simple", "staging", tt.mode, tt.key, tt.columns); got != tt.want {
+				t.Errorf("LoadQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStagingTableQuery(t *testing.T) {
+	if got := stagingTableQuery("public.simple", nil); got != `CREATE TEMP TABLE "pg_temp"."subsetter_load" AS SELECT * FROM "public"."simple" WITH NO DATA` {
+		t.Errorf("stagingTableQuery() = %v", got)
+	}
+	if got := stagingTableQuery("public.simple", []string{"id"}); got != `CREATE TEMP TABLE "pg_temp"."subsetter_load" AS SELECT "id" FROM "public"."simple" WITH NO DATA` {
+		t.Errorf("stagingTableQuery() = %v", got)
+	}
+}
+
+func TestSync_copyQueryLoadIdentity(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	for _, conn := range []*pgxpool.Pool{src, dst} {
+		if _, err := conn.Exec(context.Background(), `CREATE TABLE identity (id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY, text TEXT)`); err != nil {
+			t.Fatal(err)
+		}
+		defer func(conn *pgxpool.Pool) {
+			_, _ = conn.Exec(context.Background(), `DROP TABLE identity`)
+		}(conn)
+	}
+	// Only in the destination, filled with its default when copying the common columns
+	if _, err := dst.Exec(context.Background(), `ALTER TABLE identity ADD COLUMN created timestamptz NOT NULL DEFAULT now()`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Exec(context.Background(), `INSERT INTO identity (text) SELECT 'test' || i FROM generate_series(1, 10) AS i`); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Sync{source: src, destination: dst, loadMode: LoadUpdate, columns: map[string][]string{"public.identity": {"id", "text"}}}
+	for i := 0; i < 2; i++ {
+		if _, err := s.copyQuery(`SELECT "id", "text" FROM "public"."identity"`, "public.identity"); err != nil {
+			t.Fatalf("Sync.copyQuery() error = %v", err)
+		}
+	}
+	if count, _ := CountRows("identity", dst); count != 10 {
+		t.Errorf("CountRows() = %v, want 10", count)
+	}
+}
+
+func TestSync_copyQueryLoad(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	initSchema(dst)
+	defer clearSchema(src)
+	defer clearSchema(dst)
+
+	populateTestsWithData(src, "simple", 10)
+
+	s := &Sync{source: src, destination: dst, loadMode: LoadSkip}
+	for i := 0; i < 2; i++ {
+		if _, err := s.copyQuery(`SELECT * FROM "public"."simple"`, "public.simple"); err != nil {
+			t.Fatalf("Sync.copyQuery() error = %v", err)
+		}
+	}
+	if count, _ := CountRows("simple", dst); count != 10 {
+		t.Errorf("CountRows() = %v, want 10", count)
+	}
+}
@@ -0,0 +1,150 @@
+package subsetter
+
+// Option configures a Sync.
+type Option func(*Sync)
+
+// WithFraction sets the fraction of rows to copy.
+func WithFraction(fraction float64) Option {
+	return func(s *Sync) {
+		s.fraction = fraction
+	}
+}
+
+// WithSample sets how many rows to copy of every table, the table of the sample is
+// ignored. Unless the sample sets a fraction, the fraction of WithFraction is used.
+func WithSample(sample TableSample) Option {
+	return func(s *Sync) {
+		s.sampling = sample
+	}
+}
+
+// WithTableFraction sets the fraction of rows to copy for tables matching a pattern,
+// the first matching pattern wins.
+func WithTableFraction(table string, fraction float64) Option {
+	return WithTableSample(TableSample{Table: table, Fraction: fraction})
+}
+
+// WithTableSample overrides how many rows to copy for tables matching a pattern,
+// the first matching pattern wins.
+func WithTableSample(sample TableSample) Option {
+	return func(s *Sync) {
+		s.samples = append(s.samples, sample)
+	}
+}
+
+// WithTableSampling reads rows of tables with at least rows rows in the source with
+// TABLESAMPLE, instead of scanning the whole table. Only tables not selected by their
+// relations are sampled this way.
+func WithTableSampling(method TableSampleMethod, rows int) Option {
+	return func(s *Sync) {
+		s.tableSampling = method
+		s.tableSamplingRows = rows
+	}
+}
+
+// WithSchemas adds schema patterns to copy tables from.
+func WithSchemas(schemas ...string) Option {
+	return func(s *Sync) {
+		s.schemas = append(s.schemas, schemas...)
+	}
+}
+
+// WithInclude adds rules for rows that must be copied.
+func WithInclude(rules ...Rule) Option {
+	return func(s *Sync) {
+		s.include = append(s.include, rules...)
+	}
+}
+
+// WithExclude adds rules for tables and rows that must not be copied.
+func WithExclude(rules ...Rule) Option {
+	return func(s *Sync) {
+		s.exclude = append(s.exclude, rules...)
+	}
+}
+
+// WithVerbose shows more information during sync.
+func WithVerbose(verbose bool) Option {
+	return func(s *Sync) {
+		s.verbose = verbose
+	}
+}
+
+// WithSeed sets the seed that makes masked values and sampled rows deterministic.
+func WithSeed(seed string) Option {
+	return func(s *Sync) {
+		s.seed = seed
+	}
+}
+
+// WithTransform masks a column of tables matching a pattern.
+func WithTransform(table string, column string, transform Transform) Option {
+	return func(s *Sync) {
+		s.transforms = append(s.transforms, ColumnTransform{Table: table, Column: column, Transform: transform})
+	}
+}
+
+// WithKeyStaging selects rows referencing copied rows by staging their keys in temporary
+// tables on the source, instead of sending them as lists of keys. Without it keys are only
+// staged for relations referencing more than keyStagingThreshold keys.
+func WithKeyStaging(keyStaging bool) Option {
+	return func(s *Sync) {
+		s.keyStaging = keyStaging
+	}
+}
+
+// WithKeyStore sets the store recording the keys of copied rows, see OpenKeyStore.
+func WithKeyStore(store KeyStore) Option {
+	return func(s *Sync) {
+		s.keys = store
+	}
+}
+
+// WithState keeps a checkpoint of the sync in a state, which is also used as key store.
+func WithState(state *State) Option {
+	return func(s *Sync) {
+		s.state = state
+		s.keys = state
+	}
+}
+
+// WithLoadMode sets how copied rows are written into tables, see LoadMode.
+func WithLoadMode(mode LoadMode) Option {
+	return func(s *Sync) {
+		s.loadMode = mode
+	}
+}
+
+// WithTruncate truncates the destination tables before copying them, in one statement
+// listing tables referencing others first. With cascade tables referencing them that
+// are not copied are truncated as well. Tables copied by a resumed sync are kept.
+func WithTruncate(cascade bool) Option {
+	return func(s *Sync) {
+		s.truncate = true
+		s.cascade = cascade
+	}
+}
+
+// WithSchemaCreation creates the tables of the source missing in the destination, with the
+// extensions, enums and sequences they use. Foreign keys and indexes are created once
+// rows are copied.
+func WithSchemaCreation(create bool) Option {
+	return func(s *Sync) {
+		s.createSchema = create
+	}
+}
+
+// WithSchemaCheck sets what to do when columns of tables differ between the source and
+// the destination, see SchemaCheck. Syncs abort by default.
+func WithSchemaCheck(check SchemaCheck) Option {
+	return func(s *Sync) {
+		s.schemaCheck = check
+	}
+}
+
+// WithJobs sets how many tables are copied at once.
+func WithJobs(jobs int) Option {
+	return func(s *Sync) {
+		s.jobs = jobs
+	}
+}
@@ -0,0 +1,234 @@
+package subsetter
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// Plan lists what a sync would copy, see Sync.Plan.
+type Plan struct {
+	Schema   []string
+	Deferred []string
+	Truncate string
+	Tables   []PlanTable
+	Related  []PlanTable
+	Excluded []string
+}
+
+// PlanTable lists the queries copying rows of a table. Rows is the estimated number
+// of rows in the source and Target the number of rows selected by the fraction.
+type PlanTable struct {
+	Table   string
+	Rows    int
+	Target  int
+	Sample  string
+	Queries []string
+}
+
+// destinationKeys is a placeholder for keys read from the destination while syncing.
+func destinationKeys(table string) string {
+	return fmt.Sprintf("<keys of %s in destination>", table)
+}
+
+// planPredicate returns the predicate matching columns against a placeholder list of keys,
+// in the form of InPredicate or, for the NOT IN operator, NotInPredicate.
+func planPredicate(columns []string, operator string, keys string) string {
+	if len(columns) == 1 && operator == "IN" {
+		return fmt.Sprintf(`%s = ANY(%s)`, columns[0], keys)
+	}
+	if len(columns) == 1 {
+		return fmt.Sprintf(`%s <> ALL(%s)`, columns[0], keys)
+	}
+	return fmt.Sprintf(`%s %s (%s)`, rowValue(columns), operator, keys)
+}
+
+// Plan resolves the tables a sync would copy, in the order they would be copied,
+// with the queries that would be issued. Keys that are read from the destination
+// while syncing are shown as placeholders, the destination is not accessed.
+func (s *Sync) Plan() (plan Plan, err error) {
+	if s.source == nil {
+		return plan, errors.New("No source database configured")
+	}
+
+	tables, excluded, err := s.tables()
+	if err != nil {
+		return
+	}
+	plan.Excluded = excluded
+	if s.createSchema {
+		schema, err := GetSchema(s.schemas, s.source)
+		if err != nil {
+			return plan, errors.Wrap(err, "Error reading source schema")
+		}
+		plan.Schema, plan.Deferred = schema.Statements(false), schema.Statements(true)
+	}
+	if s.truncate {
+		plan.Truncate = TruncateQuery(truncateOrder(tables), s.cascade)
+	}
+	targets := s.targetSet(tables)
+
+	schedule := newSchedule(tables)
+	for schedule.pending() {
+		name, ok := schedule.next()
+		if !ok {
+			name, _ = schedule.breakCycle()
+		}
+		unfinished := schedule.unfinished()
+		schedule.finish(name)
+
+		i := slices.IndexFunc(tables, func(table Table) bool { return table.FullName() == name })
+		step, err := s.planTable(targets[i], unfinished)
+		if err != nil {
+			return plan, err
+		}
+		step.Rows = tables[i].Rows
+		if step.Target > 0 {
+			step.Sample = s.tableSample(name).String()
+		}
+		plan.Tables = append(plan.Tables, step)
+	}
+
+	plan.Related, err = s.planRequired(tables)
+	return
+}
+
+// planTable returns the queries copyTable and copyIncluded would issue for a table.
+func (s *Sync) planTable(table Table, unfinished []string) (step PlanTable, err error) {
+	step.Table = table.FullName()
+	includes := lo.Filter(s.include, func(rule Rule, _ int) bool { return rule.Matches(table.FullName()) })
+
+	if len(table.Relations) > 0 || len(includes) == 0 {
+		relatedQueries := []string{}
+		for i, relation := range table.Relations {
+			if relation.IsSelfRelated() || slices.Contains(unfinished, relation.ForeignTable) {
+				continue
+			}
+			if s.keyStaging {
+				relatedQueries = append(relatedQueries, stagedPredicate(relation.PrimaryColumns, stagingTable(i)))
+				continue
+			}
+			relatedQueries = append(relatedQueries, planPredicate(QuoteColumns(relation.PrimaryColumns), "IN", destinationKeys(relation.ForeignTable)))
+		}
+
+		withLimit := s.withLimit(table, relatedQueries)
+		if withLimit || len(relatedQueries) == 0 {
+			step.Target = table.Rows
+		}
+		step.Queries = append(step.Queries, s.tableDataQuery(table, relatedQueries, withLimit))
+	}
+
+	for _, include := range includes {
+		include := Rule{Table: table.FullName(), Where: include.Where}
+		key, err := s.planKey(table.FullName())
+		if err != nil {
+			return step, err
+		}
+		step.Queries = append(step.Queries, include.query(planPredicate(key, "NOT IN", destinationKeys(table.FullName()))))
+	}
+	return
+}
+
+// planRequired returns the queries copyRequired would issue.
+func (s *Sync) planRequired(tables []Table) (steps []PlanTable, err error) {
+	for _, table := range tables {
+		if len(table.Relations) > 0 {
+			continue
+		}
+		for _, include := range s.include {
+			if !include.Matches(table.FullName()) || include.Where == RuleAll {
+				continue
+			}
+
+			required, err := requiredSteps(tables, table.FullName())
+			if err != nil {
+				return nil, err
+			}
+			selected := map[string]string{table.FullName(): include.Where}
+			for _, step := range required {
+				name := step.table.FullName()
+				queries, predicates := []string{}, []string{}
+				for _, relation := range step.relations {
+					parent := Rule{Table: relation.ForeignTable, Where: selected[relation.ForeignTable]}
+					queries = append(queries, parent.includedKeysQuery(relation))
+					predicates = append(predicates, planPredicate(QuoteColumns(relation.PrimaryColumns), "IN", fmt.Sprintf("<keys of %s in source>", relation.ForeignTable)))
+				}
+				selected[name] = "(" + strings.Join(predicates, " OR ") + ")"
+
+				key, err := s.planKey(name)
+				if err != nil {
+					return nil, err
+				}
+				rule := Rule{Table: name, Where: selected[name]}
+				steps = append(steps, PlanTable{
+					Table:   name,
+					Rows:    step.table.Rows,
+					Queries: append(queries, rule.query(planPredicate(key, "NOT IN", destinationKeys(name)))),
+				})
+			}
+		}
+	}
+	return
+}
+
+// planKey returns the row key of a table, read from the source as the destination is not accessed.
+func (s *Sync) planKey(table string) ([]string, error) {
+	names, err := GetRowKey(table, s.source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error getting primary key for table %s", table)
+	}
+	return RowKey(table, names), nil
+}
+
+// Print writes the plan in a human readable form.
+func (p *Plan) Print(w io.Writer) {
+	if len(p.Schema) > 0 {
+		fmt.Fprintln(w, "Creating schema:")
+		for _, q := range p.Schema {
+			fmt.Fprintf(w, "   %s\n", q)
+		}
+		fmt.Fprintln(w)
+	}
+	if p.Truncate != "" {
+		fmt.Fprintf(w, "Truncating:\n   %s\n\n", p.Truncate)
+	}
+	fmt.Fprintln(w, "Plan:")
+	for i, table := range p.Tables {
+		if table.Target > 0 {
+			fmt.Fprintf(w, "%d. %s: ~%d rows in source, copying %d (%s)\n", i+1, table.Table, table.Rows, table.Target, table.Sample)
+		} else {
+			fmt.Fprintf(w, "%d. %s: ~%d rows in source, copying referencing rows\n", i+1, table.Table, table.Rows)
+		}
+		for _, q := range table.Queries {
+			fmt.Fprintf(w, "   %s\n", q)
+		}
+	}
+
+	if len(p.Related) > 0 {
+		fmt.Fprintln(w, "\nRows referencing included rows:")
+		for _, table := range p.Related {
+			fmt.Fprintf(w, "- %s\n", table.Table)
+			for _, q := range table.Queries {
+				fmt.Fprintf(w, "   %s\n", q)
+			}
+		}
+	}
+
+	if len(p.Deferred) > 0 {
+		fmt.Fprintln(w, "\nCompleting schema:")
+		for _, q := range p.Deferred {
+			fmt.Fprintf(w, "   %s\n", q)
+		}
+	}
+
+	if len(p.Excluded) > 0 {
+		fmt.Fprintln(w, "\nExcluded tables:")
+		for _, table := range p.Excluded {
+			fmt.Fprintf(w, "- %s\n", table)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package subsetter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSync_Plan(t *testing.T) {
+	src := getTestConnection()
+	initSchema(src)
+	defer clearSchema(src)
+
+	populateTestsWithData(src, "simple", 100)
+
+	s := &Sync{
+		source:   src,
+		fraction: 0.5,
+		include:  []Rule{{"simple", "text = 'test1'"}},
+	}
+
+	plan, err := s.Plan()
+	if err != nil {
+		t.Fatalf("Sync.Plan() error = %v", err)
+	}
+	if len(plan.Tables) != 2 || plan.Tables[0].Table != "public.simple" || plan.Tables[1].Table != "public.relation" {
+		t.Fatalf("Sync.Plan() tables = %v, want public.simple before public.relation", plan.Tables)
+	}
+
+	want := `SELECT * FROM "public"."relation" WHERE "simple_id" = ANY(<keys of public.simple in destination>) order by random() `
+	if got := plan.Tables[1].Queries[0]; got != want {
+		t.Errorf("Sync.Plan() query = %v, want %v", got, want)
+	}
+	if len(plan.Related) != 1 || !strings.Contains(plan.Related[0].Queries[0], "text = 'test1'") {
+		t.Errorf("Sync.Plan() related = %v, want rows of public.relation referencing included rows", plan.Related)
+	}
+}
+
+func TestPlan_Print(t *testing.T) {
+	plan := Plan{
+		Tables: []PlanTable{
+			{"public.users", 100, 10, "log 0.5", []string{`SELECT * FROM "public"."users"   LIMIT 10`}},
+		},
+		Excluded: []string{"public.domains"},
+	}
+
+	var b strings.Builder
+	plan.Print(&b)
+	want := `Plan:
+1. public.users: ~100 rows in source, copying 10 (log 0.5)
+   SELECT * FROM "public"."users"   LIMIT 10
+
+Excluded tables:
+- public.domains
+`
+	if b.String() != want {
+		t.Errorf("Plan.Print() = %q, want %q", b.String(), want)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"path"
 	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -11,17 +12,34 @@ import (
 	"github.com/samber/lo"
 )
 
+// DefaultSchema is the schema used for table names that are not schema qualified.
+const DefaultSchema = "public"
+
 type Table struct {
+	Schema     string
 	Name       string
 	Rows       int
 	Relations  []Relation
 	RequiredBy []Relation
 }
 
+// FullName returns the schema qualified name of the table, e.g. "public.users".
+func (t *Table) FullName() string {
+	if t.Schema == "" {
+		return t.Name
+	}
+	return t.Schema + "." + t.Name
+}
+
+// QuotedName returns the schema qualified name of the table quoted for use in SQL.
+func (t *Table) QuotedName() string {
+	return QuoteTable(t.FullName())
+}
+
 // RelationNames returns a list of relation names in human readable format.
 func (t *Table) RelationNames() (names []string) {
 	names = lo.Map(t.Relations, func(r Relation, _ int) string {
-		return r.PrimaryTable + ">" + r.PrimaryColumn
+		return r.PrimaryTable + ">" + strings.Join(r.PrimaryColumns, ",")
 	})
 
 	return
@@ -37,29 +55,68 @@ func (t *Table) IsSelfRelated() bool {
 	return false
 }
 
-// IsSelfRelated returns true if a table is self related.
+// TableByName returns a table by its name, unqualified names are looked up in the default schema.
 func TableByName(tables []Table, name string) Table {
+	name = QualifiedName(name)
 	return lo.FindOrElse(tables, Table{}, func(t Table) bool {
-		return t.Name == name
+		return t.FullName() == name
+	})
+}
+
+// QualifiedName returns a schema qualified table name, adding the default schema if missing.
+func QualifiedName(name string) string {
+	if strings.Contains(name, ".") {
+		return name
+	}
+	return DefaultSchema + "." + name
+}
+
+// MatchTable reports whether a table matches a pattern, patterns may contain globs
+// such as "domains_*" and unqualified patterns match tables in the default schema.
+func MatchTable(pattern string, table string) bool {
+	matched, _ := path.Match(QualifiedName(pattern), QualifiedName(table))
+	return matched
+}
+
+// MatchSchema reports whether a schema matches any of the glob patterns,
+// no patterns match only the default schema.
+func MatchSchema(patterns []string, schema string) bool {
+	if len(patterns) == 0 {
+		return schema == DefaultSchema
+	}
+	return lo.SomeBy(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, schema)
+		return matched
 	})
 }
 
-// GetTablesWithRows returns a list of tables with the number of rows in each table.
-func GetTablesWithRows(conn *pgxpool.Pool) (tables []Table, err error) {
+// GetTablesWithRows returns a list of tables with the number of rows in each table,
+// for all schemas matching the patterns.
+func GetTablesWithRows(schemas []string, conn *pgxpool.Pool) (tables []Table, err error) {
 	q := `SELECT
-		relname,
-		reltuples::int
+		n.nspname,
+		c.relname,
+		c.reltuples::int
 	FROM
-		pg_class,
-		information_schema.tables
+		pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
 	WHERE
-		table_schema = 'public'
-		AND relname = table_name;`
+		c.relkind IN ('r', 'p')
+		AND NOT c.relispartition
+		AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		AND n.nspname NOT LIKE 'pg_toast%';`
 	rows, err := conn.Query(context.Background(), q)
+	if err != nil {
+		return
+	}
 	for rows.Next() {
 		var table Table
 
-		if err := rows.Scan(&table.Name, &table.Rows); err == nil {
+		if err := rows.Scan(&table.Schema, &table.Name, &table.Rows); err == nil {
+			if !MatchSchema(schemas, table.Schema) {
+				continue
+			}
+
 			// skip system tables that are marked public
 			if strings.HasPrefix(table.Name, "pg_") {
 				continue
@@ -72,17 +129,17 @@ func GetTablesWithRows(conn *pgxpool.Pool) (tables []Table, err error) {
 
 			// Do a precise count for small tables
 			if table.Rows == 0 {
-				table.Rows, err = CountRows(table.Name, conn)
+				table.Rows, err = CountRows(table.FullName(), conn)
 				if err != nil {
 					return nil, err
 				}
 			}
 
 			// Get relations
-			table.Relations = GetRelations(table.Name, conn)
+			table.Relations = GetRelations(table.FullName(), conn)
 
 			// Get reverse relations
-			table.RequiredBy = GetRequiredBy(table.Name, conn)
+			table.RequiredBy = GetRequiredBy(table.FullName(), conn)
 
 			tables = append(tables, table)
 		}
@@ -93,45 +150,136 @@ func GetTablesWithRows(conn *pgxpool.Pool) (tables []Table, err error) {
 	return
 }
 
-// GetKeys returns a list of keys from a query.
-func GetKeys(q string, conn *pgxpool.Pool) (ids []string, err error) {
+// GetKeys returns a list of keys from a query, a key holds the value of every selected column.
+func GetKeys(q string, conn *pgxpool.Pool) (keys [][]string, err error) {
 	rows, err := conn.Query(context.Background(), q)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
 	for rows.Next() {
-		var id string
+		key := make([]string, len(rows.FieldDescriptions()))
+		values := lo.Map(key, func(_ string, i int) any {
+			return &key[i]
+		})
 
-		if err := rows.Scan(&id); err == nil {
-			ids = append(ids, id)
+		if err := rows.Scan(values...); err == nil {
+			keys = append(keys, key)
 		}
 
 	}
-	rows.Close()
 
-	return
+	return keys, rows.Err()
 }
 
-// GetPrimaryKeyName returns the name of the primary key for a table.
-func GetPrimaryKeyName(table string, conn *pgxpool.Pool) (name string, err error) {
-	q := fmt.Sprintf(`SELECT a.attname
+// GetPrimaryKeyName returns the names of the primary key columns for a table, in key order.
+func GetPrimaryKeyName(table string, conn *pgxpool.Pool) (names []string, err error) {
+	q := `SELECT a.attname
 	FROM   pg_index i
 	JOIN   pg_attribute a ON a.attrelid = i.indrelid
 	AND a.attnum = ANY(i.indkey)
-	WHERE  i.indrelid = '%s'::regclass
-	AND    i.indisprimary;`, table)
-	rows, err := conn.Query(context.Background(), q)
+	WHERE  i.indrelid = $1::regclass
+	AND    i.indisprimary
+	ORDER BY array_position(i.indkey::int2[], a.attnum);`
+	rows, err := conn.Query(context.Background(), q, QuoteTable(table))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
 	for rows.Next() {
+		var name string
 		if err := rows.Scan(&name); err != nil {
-			return "", err
+			return nil, err
 		}
+		names = append(names, name)
 	}
-	rows.Close()
-	return
+	return names, rows.Err()
 }
 
-// DeleteRows deletes rows from a table.
-func DeleteRows(table string, where string, conn *pgxpool.Pool) (err error) {
-	q := fmt.Sprintf(`DELETE FROM %s WHERE %s`, table, where)
-	_, err = conn.Exec(context.Background(), q)
-	return
+// GetColumns returns the names of the columns of a table, in table order.
+func GetColumns(table string, conn *pgxpool.Pool) (names []string, err error) {
+	q := `SELECT attname
+	FROM   pg_attribute
+	WHERE  attrelid = $1::regclass
+	AND    attnum > 0
+	AND    NOT attisdropped
+	ORDER BY attnum;`
+	rows, err := conn.Query(context.Background(), q, QuoteTable(table))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// GetRowKey returns the columns identifying rows of a table: the primary key or, when
+// missing, the smallest unique index on NOT NULL columns. No columns are returned for
+// tables without either, see RowKey.
+func GetRowKey(table string, conn *pgxpool.Pool) (names []string, err error) {
+	if names, err = GetPrimaryKeyName(table, conn); err != nil || len(names) > 0 {
+		return
+	}
+
+	q := `SELECT ARRAY(
+		SELECT a.attname::text
+		FROM unnest(i.indkey::int2[]) WITH ORDINALITY AS k(attnum, position)
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+		WHERE k.position <= i.indnkeyatts
+		ORDER BY k.position
+	)
+	FROM   pg_index i
+	WHERE  i.indrelid = $1::regclass
+	AND    i.indisunique
+	AND    i.indpred IS NULL
+	AND    i.indexprs IS NULL
+	AND    NOT EXISTS (
+		SELECT 1 FROM pg_attribute a
+		WHERE a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey) AND NOT a.attnotnull
+	)
+	ORDER BY i.indnkeyatts, i.indexrelid
+	LIMIT 1;`
+	rows, err := conn.Query(context.Background(), q, QuoteTable(table))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&names); err != nil {
+			return nil, err
+		}
+	}
+	return names, rows.Err()
+}
+
+// RowKey returns the expressions comparing rows of a table by their quoted key columns.
+// Rows of tables without a key are compared on all columns, using a hash of the whole row.
+func RowKey(table string, columns []string) []string {
+	if len(columns) > 0 {
+		return QuoteColumns(columns)
+	}
+	name := strings.SplitN(QualifiedName(table), ".", 2)[1]
+	return []string{fmt.Sprintf("md5(CAST(%s AS text))", QuoteIdentifier(name))}
+}
+
+// DeleteRows deletes rows from a table and returns the number of deleted rows.
+func DeleteRows(table string, where string, conn *pgxpool.Pool) (deleted int64, err error) {
+	tag, err := conn.Exec(context.Background(), deleteQuery(table, where))
+	if err != nil {
+		return
+	}
+	return tag.RowsAffected(), nil
+}
+
+// deleteQuery returns the query deleting rows of a table.
+func deleteQuery(table string, where string) string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE %s`, QuoteTable(table), where)
 }
 
 // CopyQueryToString copies a query to a string.
@@ -151,14 +299,19 @@ func CopyQueryToString(query string, conn *pgxpool.Pool) (result string, err err
 	return
 }
 
-// CopyTableToString copies a table to a string.
-func CopyTableToString(table string, limit string, where string, conn *pgxpool.Pool) (result string, err error) {
+// TableQuery returns the query selecting rows of a table, in random order when filtered.
+func TableQuery(table string, limit string, where string) string {
 	maybeOrder := ""
 	if lo.IsNotEmpty(where) {
 		maybeOrder = "order by random()"
 	}
 
-	q := fmt.Sprintf(`SELECT * FROM %s %s %s %s`, table, where, maybeOrder, limit)
+	return fmt.Sprintf(`SELECT * FROM %s %s %s %s`, QuoteTable(table), where, maybeOrder, limit)
+}
+
+// CopyTableToString copies a table to a string.
+func CopyTableToString(table string, limit string, where string, conn *pgxpool.Pool) (result string, err error) {
+	q := TableQuery(table, limit, where)
 	log.Debug().Msgf("CopyTableToString query: %s", q)
 	return CopyQueryToString(q, conn)
 }
@@ -166,7 +319,7 @@ func CopyTableToString(table string, limit string, where string, conn *pgxpool.P
 // CopyStringToTable copies a string to a table.
 func CopyStringToTable(table string, data string, conn *pgxpool.Pool) (err error) {
 	log.Debug().Msgf("CopyStringToTable query: %s", table)
-	q := fmt.Sprintf(`copy %s from stdin`, table)
+	q := fmt.Sprintf(`copy %s from stdin`, QuoteTable(table))
 	var buff bytes.Buffer
 	buff.WriteString(data)
 	c, err := conn.Acquire(context.Background())
@@ -184,7 +337,7 @@ func CopyStringToTable(table string, data string, conn *pgxpool.Pool) (err error
 
 // CountRows returns the number of rows in a table.
 func CountRows(s string, conn *pgxpool.Pool) (count int, err error) {
-	q := "SELECT count(*) FROM " + s
+	q := "SELECT count(*) FROM " + QuoteTable(s)
 	err = conn.QueryRow(context.Background(), q).Scan(&count)
 	if err != nil {
 		return
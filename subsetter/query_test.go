@@ -19,18 +19,18 @@ func TestGetTablesWithRows(t *testing.T) {
 	}{
 		{"With tables", conn,
 			[]Table{
-				{"simple", 0, []Relation{}, []Relation{}},
-				{"relation", 0, []Relation{}, []Relation{}},
+				{"public", "simple", 0, []Relation{}, []Relation{}},
+				{"public", "relation", 0, []Relation{}, []Relation{}},
 			}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotTables, err := GetTablesWithRows(tt.conn)
+			gotTables, err := GetTablesWithRows(nil, tt.conn)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetTablesWithRows() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if gotTables[0].Name != tt.wantTables[0].Name {
+			if gotTables[0].FullName() != tt.wantTables[0].FullName() {
 				t.Errorf("GetTablesWithRows() = %v, want %v", gotTables, tt.wantTables)
 			}
 			if gotTables[0].Rows != tt.wantTables[0].Rows {
@@ -118,7 +118,7 @@ func TestDeleteRows(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := DeleteRows(tt.table, tt.where, tt.conn); (err != nil) != tt.wantErr {
+			if _, err := DeleteRows(tt.table, tt.where, tt.conn); (err != nil) != tt.wantErr {
 				t.Errorf("DeleteRows() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if gotCount, _ := CountRows(tt.table, tt.conn); gotCount != tt.count {
@@ -127,3 +127,47 @@ func TestDeleteRows(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		schema   string
+		want     bool
+	}{
+		{"Default schema", nil, "public", true},
+		{"Default schema only", nil, "billing", false},
+		{"Exact", []string{"public", "billing"}, "billing", true},
+		{"Glob", []string{"audit_*"}, "audit_2024", true},
+		{"No match", []string{"audit_*"}, "public", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchSchema(tt.patterns, tt.schema); got != tt.want {
+				t.Errorf("MatchSchema() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRowKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   string
+		columns []string
+		want    []string
+	}{
+		{"Primary key", "users", []string{"uuid"}, []string{`"uuid"`}},
+		{"Composite key", "memberships", []string{"user_id", "group_id"}, []string{`"user_id"`, `"group_id"`}},
+		{"Reserved word", "order", []string{"user"}, []string{`"user"`}},
+		{"Without key on reserved word", "user", nil, []string{`md5(CAST("user" AS text))`}},
+		{"Without key", "billing.events", nil, []string{`md5(CAST("events" AS text))`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RowKey(tt.table, tt.columns); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("RowKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package subsetter
+
+import (
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/samber/lo"
+)
+
+// QuoteIdentifier quotes a single identifier, such as a column name, for use in SQL.
+func QuoteIdentifier(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// QuoteTable quotes a possibly schema qualified table name for use in SQL.
+func QuoteTable(name string) string {
+	return pgx.Identifier(strings.SplitN(name, ".", 2)).Sanitize()
+}
+
+// QuoteLiteral quotes a value as a string literal for use in SQL, numbers included,
+// unlike QuoteString.
+func QuoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// QuoteColumns quotes column names for use in SQL.
+func QuoteColumns(columns []string) []string {
+	return lo.Map(columns, func(column string, _ int) string {
+		return QuoteIdentifier(column)
+	})
+}
+
+// qualifyColumns quotes column names prefixed with a table alias.
+func qualifyColumns(alias string, columns []string) []string {
+	return lo.Map(columns, func(column string, _ int) string {
+		return alias + "." + QuoteIdentifier(column)
+	})
+}
@@ -0,0 +1,85 @@
+package subsetter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestQuoteTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		want  string
+	}{
+		{"Unqualified", "simple", `"simple"`},
+		{"Qualified", "billing.invoices", `"billing"."invoices"`},
+		{"Mixed case", "Billing.Invoices", `"Billing"."Invoices"`},
+		{"Reserved word", "user", `"user"`},
+		{"Quotes", `public.order "items"`, `"public"."order ""items"""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuoteTable(tt.table); got != tt.want {
+				t.Errorf("QuoteTable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteColumns(t *testing.T) {
+	got := QuoteColumns([]string{"id", "user", "createdAt", `say "hi"`})
+	want := []string{`"id"`, `"user"`, `"createdAt"`, `"say ""hi"""`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QuoteColumns() = %v, want %v", got, want)
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"open", `'open'`},
+		{"1", `'1'`},
+		{"it's", `'it''s'`},
+		{"", `''`},
+	}
+	for _, tt := range tests {
+		if got := QuoteLiteral(tt.value); got != tt.want {
+			t.Errorf("QuoteLiteral(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestQuoting(t *testing.T) {
+	conn := getTestConnection()
+	if _, err := conn.Exec(context.Background(), `
+		CREATE TABLE "user" ("Id" serial PRIMARY KEY, "order" text);
+		CREATE TABLE "Order" (id serial PRIMARY KEY, "user" int REFERENCES "user" ("Id"));
+		INSERT INTO "user" ("order") VALUES ('a'), ('b');
+		INSERT INTO "Order" ("user") VALUES (1), (2);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Exec(context.Background(), `DROP TABLE "Order"; DROP TABLE "user";`)
+
+	if got, err := GetPrimaryKeyName("user", conn); err != nil || !reflect.DeepEqual(got, []string{"Id"}) {
+		t.Errorf("GetPrimaryKeyName() = %v, %v, want [Id]", got, err)
+	}
+	if got, err := GetColumns("public.Order", conn); err != nil || !reflect.DeepEqual(got, []string{"id", "user"}) {
+		t.Errorf("GetColumns() = %v, %v, want [id user]", got, err)
+	}
+	if got, err := GetKeys(keysQuery("Order", QuoteColumns([]string{"user"})), conn); err != nil || len(got) != 2 {
+		t.Errorf("GetKeys() = %v, %v, want 2 keys", got, err)
+	}
+	if _, err := CopyTableToString("user", "", "", conn); err != nil {
+		t.Errorf("CopyTableToString() error = %v", err)
+	}
+	if deleted, err := DeleteRows("Order", InPredicate(Key{QuoteColumns([]string{"user"}), []string{"integer"}}, [][]string{{"1"}}), conn); err != nil || deleted != 1 {
+		t.Errorf("DeleteRows() = %v, %v, want 1", deleted, err)
+	}
+	if count, err := CountRows("Order", conn); err != nil || count != 1 {
+		t.Errorf("CountRows() = %v, %v, want 1", count, err)
+	}
+}
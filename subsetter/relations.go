@@ -3,7 +3,6 @@ package subsetter
 import (
 	"context"
 	"fmt"
-	"regexp"
 	"strings"
 	"sync"
 
@@ -12,96 +11,93 @@ import (
 	"github.com/samber/lo"
 )
 
-var cachedRelations *[]RelationRaw
+var cachedRelations *[]Relation
 var mutexCachedRelations sync.Once
 
+// Relation is a foreign key from the columns of the primary table to the
+// columns of the foreign table, table names are schema qualified.
 type Relation struct {
-	PrimaryTable  string
-	PrimaryColumn string
-	ForeignTable  string
-	ForeignColumn string
+	PrimaryTable   string
+	PrimaryColumns []string
+	ForeignTable   string
+	ForeignColumns []string
 }
 
 func (r *Relation) IsSelfRelated() bool {
 	return r.PrimaryTable == r.ForeignTable
 }
 
-func (r *Relation) Query(subset []string) string {
-
-	subset = lo.Map(subset, func(s string, _ int) string {
-		return QuoteString(s)
-	})
-
-	return fmt.Sprintf(`SELECT * FROM %s WHERE %s IN (%s)`, r.PrimaryTable, r.PrimaryColumn, strings.Join(subset, ","))
+// Query returns the query selecting rows of the primary table referencing a subset of keys,
+// types are the types of the primary columns.
+func (r *Relation) Query(types []string, subset [][]string) string {
+	return fmt.Sprintf(`SELECT * FROM %s WHERE %s`, QuoteTable(r.PrimaryTable), InPredicate(Key{QuoteColumns(r.PrimaryColumns), types}, subset))
 }
 
 func (r *Relation) PrimaryQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s`, r.ForeignColumn, r.ForeignTable)
+	return fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(QuoteColumns(r.ForeignColumns), ", "), QuoteTable(r.ForeignTable))
 }
 
-// RelationRaw is a raw representation of a relation in the database.
-type RelationRaw struct {
-	PrimaryTable string
-	ForeignTable string
-	SQL          string
-}
+func getAllRelations(table string, conn *pgxpool.Pool) *[]Relation {
 
-// toRelation converts a RelationRaw to a Relation.
-func (r *RelationRaw) toRelation() Relation {
-	var rel Relation
-	re := regexp.MustCompile(`FOREIGN KEY \((\w+)\) REFERENCES (\w+)\((\w+)\).*`)
-	matches := re.FindStringSubmatch(r.SQL)
-	if len(matches) == 4 {
-		rel.PrimaryColumn = matches[1]
-		rel.ForeignTable = matches[2]
-		rel.ForeignColumn = matches[3]
-	}
-	rel.PrimaryTable = r.PrimaryTable
-	return rel
-}
+	mutexCachedRelations.Do(func() {
+		if relations, err := queryRelations(conn); err == nil {
+			cachedRelations = &relations
+		}
+	})
 
-func getAllRelations(table string, conn *pgxpool.Pool) *[]RelationRaw {
+	return cachedRelations
+}
 
-	mutexCachedRelations.Do(func() {
-		q := `SELECT
-		conrelid::regclass AS primary_table,
-		confrelid::regclass AS referenced_table,
-		pg_get_constraintdef(c.oid, TRUE) AS sql
+// queryRelations reads all foreign keys of a database, without the cache of getAllRelations.
+func queryRelations(conn *pgxpool.Pool) ([]Relation, error) {
+	q := `SELECT
+		pn.nspname || '.' || pc.relname AS primary_table,
+		ARRAY(
+			SELECT a.attname::text
+			FROM unnest(c.conkey) WITH ORDINALITY AS k(attnum, position)
+			JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+			ORDER BY k.position
+		) AS primary_columns,
+		fn.nspname || '.' || fc.relname AS referenced_table,
+		ARRAY(
+			SELECT a.attname::text
+			FROM unnest(c.confkey) WITH ORDINALITY AS k(attnum, position)
+			JOIN pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = k.attnum
+			ORDER BY k.position
+		) AS referenced_columns
 	FROM
 		pg_constraint c
-		JOIN pg_namespace n ON n.oid = c.connamespace
+		JOIN pg_class pc ON pc.oid = c.conrelid
+		JOIN pg_namespace pn ON pn.oid = pc.relnamespace
+		JOIN pg_class fc ON fc.oid = c.confrelid
+		JOIN pg_namespace fn ON fn.oid = fc.relnamespace
 	WHERE
 		c.contype = 'f'
-		AND n.nspname = 'public';`
+		AND pn.nspname NOT IN ('pg_catalog', 'information_schema');`
 
-		rows, err := conn.Query(context.Background(), q)
-		if err != nil {
-			return
-		}
-		defer rows.Close()
-		relations := []RelationRaw{}
-		for rows.Next() {
-			var rel RelationRaw
-
-			err = rows.Scan(&rel.PrimaryTable, &rel.ForeignTable, &rel.SQL)
-			if err != nil {
-				return
-			}
-			relations = append(relations, rel)
-			log.Debug().Str("table", rel.PrimaryTable).Str("foreign", rel.ForeignTable).Msg("Found relation")
-		}
-		cachedRelations = &relations
-
-	})
+	rows, err := conn.Query(context.Background(), q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	relations := []Relation{}
+	for rows.Next() {
+		var rel Relation
 
-	return cachedRelations
+		if err = rows.Scan(&rel.PrimaryTable, &rel.PrimaryColumns, &rel.ForeignTable, &rel.ForeignColumns); err != nil {
+			return nil, err
+		}
+		relations = append(relations, rel)
+		log.Debug().Str("table", rel.PrimaryTable).Str("foreign", rel.ForeignTable).Strs("columns", rel.PrimaryColumns).Msg("Found relation")
+	}
+	return relations, rows.Err()
 }
 
-// GetRelations returns a list of tables that are foreign key for particular table.
+// GetRelations returns a list of tables that are foreign key for particular schema qualified table.
 func GetRelations(table string, conn *pgxpool.Pool) (relations []Relation) {
 	for _, rel := range *getAllRelations(table, conn) {
 		if table == rel.PrimaryTable {
-			relations = append(relations, rel.toRelation())
+			relations = append(relations, rel)
 		}
 	}
 	return
@@ -111,8 +107,60 @@ func GetRelations(table string, conn *pgxpool.Pool) (relations []Relation) {
 func GetRequiredBy(table string, conn *pgxpool.Pool) (relations []Relation) {
 	for _, rel := range *getAllRelations(table, conn) {
 		if table == rel.ForeignTable {
-			relations = append(relations, rel.toRelation())
+			relations = append(relations, rel)
 		}
 	}
 	return
 }
+
+// InPredicate returns a predicate matching a key against a list of keys, passed as
+// typed arrays, e.g. a = ANY(CAST('{"1"}' AS integer[])). Multi column keys are compared
+// as row values against the unnested arrays. No keys match no rows. The arrays are part of
+// the statement text as rows are copied with COPY, which takes no parameters, so large key
+// sets are staged instead, see WithKeyStaging.
+func InPredicate(key Key, keys [][]string) string {
+	if len(keys) == 0 {
+		return "FALSE"
+	}
+	arrays := keyArrays(key, keys)
+	if len(arrays) == 1 {
+		return fmt.Sprintf(`%s = ANY(%s)`, key.Columns[0], arrays[0])
+	}
+	return fmt.Sprintf(`%s IN (SELECT * FROM unnest(%s))`, rowValue(key.Columns), strings.Join(arrays, ", "))
+}
+
+// NotInPredicate returns a predicate excluding a list of keys, see InPredicate.
+func NotInPredicate(key Key, keys [][]string) string {
+	if len(keys) == 0 {
+		return "TRUE"
+	}
+	arrays := keyArrays(key, keys)
+	if len(arrays) == 1 {
+		return fmt.Sprintf(`%s <> ALL(%s)`, key.Columns[0], arrays[0])
+	}
+	return fmt.Sprintf(`%s NOT IN (SELECT * FROM unnest(%s))`, rowValue(key.Columns), strings.Join(arrays, ", "))
+}
+
+// keyValues returns keys as a list of quoted row values, for display.
+func keyValues(keys [][]string) string {
+	return strings.Join(lo.Map(keys, func(key []string, _ int) string {
+		return rowValue(lo.Map(key, func(s string, _ int) string {
+			return QuoteString(s)
+		}))
+	}), ",")
+}
+
+// rowValue joins items into a row value, single items are returned as is.
+func rowValue(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "(" + strings.Join(items, ", ") + ")"
+}
+
+// keysQuery returns a query selecting the text value of quoted columns in a table, for use with GetKeys.
+func keysQuery(table string, columns []string) string {
+	return fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(lo.Map(columns, func(c string, _ int) string {
+		return c + "::text"
+	}), ", "), QuoteTable(table))
+}
@@ -4,7 +4,6 @@ import (
 	"reflect"
 	"testing"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -18,7 +17,7 @@ func TestGetRelations(t *testing.T) {
 		conn          *pgxpool.Pool
 		wantRelations []Relation
 	}{
-		{"With relation", "relation", conn, []Relation{{"relation", "simple_id", "simple", "id"}}},
+		{"With relation", "public.relation", conn, []Relation{{"public.relation", []string{"simple_id"}, "public.simple", []string{"id"}}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -31,49 +30,65 @@ func TestGetRelations(t *testing.T) {
 
 func TestRelation_Query(t *testing.T) {
 	tests := []struct {
-		name string
-		r    Relation
-		want string
+		name   string
+		r      Relation
+		types  []string
+		subset [][]string
+		want   string
 	}{
-		{"Simple", Relation{"simple", "id", "relation", "simple_id"}, "SELECT * FROM simple WHERE id IN (1)"},
+		{
+			"Simple",
+			Relation{"simple", []string{"id"}, "relation", []string{"simple_id"}},
+			[]string{"uuid"},
+			[][]string{{"1"}},
+			`SELECT * FROM "simple" WHERE "id" = ANY(CAST('{"1"}' AS uuid[]))`,
+		},
+		{
+			"Composite",
+			Relation{"order_items", []string{"order_id", "shop_id"}, "orders", []string{"id", "shop_id"}},
+			[]string{"bigint", "text"},
+			[][]string{{"1", "a"}, {"2", "b"}},
+			`SELECT * FROM "order_items" WHERE ("order_id", "shop_id") IN (SELECT * FROM unnest(CAST('{"1","2"}' AS bigint[]), CAST('{"a","b"}' AS text[])))`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.r.Query([]string{"1"}); got != tt.want {
+			if got := tt.r.Query(tt.types, tt.subset); got != tt.want {
 				t.Errorf("Relation.Query() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestRelationRaw_toRelation(t *testing.T) {
-
+func TestInPredicate(t *testing.T) {
 	tests := []struct {
-		name   string
-		fields RelationRaw
-		want   Relation
+		name string
+		key  Key
+		keys [][]string
+		want string
 	}{
-		{
-			"Simple",
-			RelationRaw{"relation", "simple", "FOREIGN KEY (simple_id) REFERENCES simple(id)"},
-			Relation{"relation", "simple_id", "simple", "id"},
-		},
-		{
-			"Simple with cascade",
-			RelationRaw{"relation", "simple", "FOREIGN KEY (simple_id) REFERENCES simple(id) ON DELETE CASCADE"},
-			Relation{"relation", "simple_id", "simple", "id"},
-		},
+		{"Single column", Key{[]string{"id"}, []string{"integer"}}, [][]string{{"1"}, {"2"}}, `id = ANY(CAST('{"1","2"}' AS integer[]))`},
+		{"Multiple columns", Key{[]string{"a", "b"}, []string{"integer", "text"}}, [][]string{{"1", "x"}}, `(a, b) IN (SELECT * FROM unnest(CAST('{"1"}' AS integer[]), CAST('{"x"}' AS text[])))`},
+		{"Quotes", Key{[]string{"name"}, []string{"text"}}, [][]string{{`O'Brien "Bob"`}}, `name = ANY(CAST('{"O''Brien \"Bob\""}' AS text[]))`},
+		{"Numeric text", Key{[]string{"code"}, []string{"character varying(10)"}}, [][]string{{"007"}}, `code = ANY(CAST('{"007"}' AS character varying(10)[]))`},
+		{"Bytea", Key{[]string{"hash"}, []string{"bytea"}}, [][]string{{`\xdead`}}, `hash = ANY(CAST('{"\\xdead"}' AS bytea[]))`},
+		{"No keys", Key{[]string{"id"}, []string{"integer"}}, [][]string{}, `FALSE`},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &RelationRaw{
-				PrimaryTable: tt.fields.PrimaryTable,
-				ForeignTable: tt.fields.ForeignTable,
-				SQL:          tt.fields.SQL,
-			}
-			if got := r.toRelation(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("RelationRaw.toRelation() = %v, want %v", spew.Sdump(got), spew.Sdump(tt.want))
+			if got := InPredicate(tt.key, tt.keys); got != tt.want {
+				t.Errorf("InPredicate() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestNotInPredicate(t *testing.T) {
+	key := Key{[]string{"id"}, []string{"integer"}}
+	if got, want := NotInPredicate(key, [][]string{{"1"}}), `id <> ALL(CAST('{"1"}' AS integer[]))`; got != want {
+		t.Errorf("NotInPredicate() = %v, want %v", got, want)
+	}
+	if got := NotInPredicate(key, nil); got != "TRUE" {
+		t.Errorf("NotInPredicate() = %v, want TRUE", got)
+	}
+}
@@ -0,0 +1,95 @@
+package subsetter
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// Report describes the outcome of a sync, per table.
+type Report struct {
+	Started  time.Time     `json:"started"`
+	Seconds  float64       `json:"duration_seconds"`
+	Tables   []TableReport `json:"tables"`
+	Excluded []string      `json:"excluded_tables"`
+}
+
+// TableReport describes the outcome of a sync for a table. SourceRows is the estimated
+// number of rows in the source, TargetRows the number of rows selected by the fraction
+// and Rows the number of rows in the destination after the sync. Skipped tables were
+// copied by an earlier sync that was resumed.
+type TableReport struct {
+	Table      string   `json:"table"`
+	SourceRows int      `json:"source_rows"`
+	TargetRows int      `json:"target_rows"`
+	CopiedRows int64    `json:"copied_rows"`
+	Rows       int      `json:"rows"`
+	Bytes      int64    `json:"bytes"`
+	Seconds    float64  `json:"duration_seconds"`
+	Retries    int      `json:"retries"`
+	Skipped    bool     `json:"skipped"`
+	Warnings   []string `json:"warnings"`
+}
+
+// Write writes the report as JSON.
+func (r *Report) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Report returns the report of the last sync.
+func (s *Sync) Report() Report {
+	s.reportMutex.Lock()
+	defer s.reportMutex.Unlock()
+
+	report := s.report
+	report.Tables = lo.Map(s.report.Tables, func(table TableReport, _ int) TableReport {
+		table.Warnings = append([]string{}, table.Warnings...)
+		return table
+	})
+	return report
+}
+
+// record updates the report of a table, adding it to the report when missing.
+func (s *Sync) record(table string, update func(*TableReport)) {
+	s.reportMutex.Lock()
+	defer s.reportMutex.Unlock()
+
+	table = QualifiedName(table)
+	for i := range s.report.Tables {
+		if s.report.Tables[i].Table == table {
+			update(&s.report.Tables[i])
+			return
+		}
+	}
+	s.report.Tables = append(s.report.Tables, TableReport{Table: table, Warnings: []string{}})
+	update(&s.report.Tables[len(s.report.Tables)-1])
+}
+
+// recordCopy records the rows and bytes copied into a table.
+func (s *Sync) recordCopy(table string, stats CopyStats) {
+	s.record(table, func(t *TableReport) {
+		t.CopiedRows += stats.Rows
+		t.Bytes += stats.Bytes
+	})
+}
+
+// warn records a warning for a table.
+func (s *Sync) warn(table string, warning string) {
+	s.record(table, func(t *TableReport) {
+		t.Warnings = append(t.Warnings, warning)
+	})
+}
+
+// timed records how long copying a table takes.
+func (s *Sync) timed(table string, f func() error) error {
+	start := time.Now()
+	err := f()
+	s.record(table, func(t *TableReport) {
+		t.Seconds += time.Since(start).Seconds()
+	})
+	return err
+}
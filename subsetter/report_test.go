@@ -0,0 +1,43 @@
+package subsetter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSync_record(t *testing.T) {
+	s := &Sync{}
+	s.record("users", func(t *TableReport) { t.CopiedRows += 10 })
+	s.record("public.users", func(t *TableReport) { t.CopiedRows += 5 })
+	s.warn("billing.invoices", "Transferring failed")
+
+	report := s.Report()
+	if len(report.Tables) != 2 {
+		t.Fatalf("Sync.Report() tables = %v, want 2", report.Tables)
+	}
+	if got := report.Tables[0]; got.Table != "public.users" || got.CopiedRows != 15 {
+		t.Errorf("Sync.Report() table = %+v, want 15 rows copied into public.users", got)
+	}
+	if got := report.Tables[1].Warnings; len(got) != 1 || got[0] != "Transferring failed" {
+		t.Errorf("Sync.Report() warnings = %v", got)
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	report := Report{Tables: []TableReport{{Table: "public.users", CopiedRows: 3, Warnings: []string{}}}}
+
+	var b strings.Builder
+	if err := report.Write(&b); err != nil {
+		t.Fatalf("Report.Write() error = %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+		t.Fatalf("Report.Write() wrote invalid JSON: %v", err)
+	}
+	table := got["tables"].([]any)[0].(map[string]any)
+	if table["table"] != "public.users" || table["copied_rows"] != float64(3) {
+		t.Errorf("Report.Write() table = %v", table)
+	}
+}
@@ -0,0 +1,73 @@
+package subsetter
+
+import "testing"
+
+func TestRule_Query(t *testing.T) {
+	id := Key{[]string{`"id"`}, []string{"integer"}}
+	tests := []struct {
+		name    string
+		rule    Rule
+		key     Key
+		exclude [][]string
+		want    string
+	}{
+		{"Without rows", Rule{"users", "id = 1"}, id, nil, `SELECT * FROM "users" WHERE (id = 1)`},
+		{"Without where", Rule{"users", ""}, id, [][]string{{"1"}}, `SELECT * FROM "users" WHERE "id" <> ALL(CAST('{"1"}' AS integer[]))`},
+		{"With rows", Rule{"users", "id < 10"}, id, [][]string{{"1"}, {"2"}}, `SELECT * FROM "users" WHERE (id < 10) AND "id" <> ALL(CAST('{"1","2"}' AS integer[]))`},
+		{
+			"Without key",
+			Rule{"events", "kind = 'signup'"},
+			Key{RowKey("events", nil), []string{"text"}},
+			[][]string{{"9e107d9d372bb6826bd81d3542a419d6"}},
+			`SELECT * FROM "events" WHERE (kind = 'signup') AND md5(CAST("events" AS text)) <> ALL(CAST('{"9e107d9d372bb6826bd81d3542a419d6"}' AS text[]))`,
+		},
+		{
+			"With composite key",
+			Rule{"memberships", RuleAll},
+			Key{RowKey("memberships", []string{"user_id", "group_id"}), []string{"integer", "uuid"}},
+			[][]string{{"1", "2"}},
+			`SELECT * FROM "memberships" WHERE (1=1) AND ("user_id", "group_id") NOT IN (SELECT * FROM unnest(CAST('{"1"}' AS integer[]), CAST('{"2"}' AS uuid[])))`,
+		},
+		{
+			"With OR",
+			Rule{"users", "id = 1 OR id = 2"},
+			id,
+			[][]string{{"1"}},
+			`SELECT * FROM "users" WHERE (id = 1 OR id = 2) AND "id" <> ALL(CAST('{"1"}' AS integer[]))`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Query(tt.key, tt.exclude); got != tt.want {
+				t.Errorf("Rule.Query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRule_CopyMaskedKeys(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	initSchema(dst)
+	defer clearSchema(src)
+	defer clearSchema(dst)
+
+	populateTestsWithData(src, "simple", 10)
+
+	s := &Sync{
+		source:      src,
+		destination: dst,
+		transforms:  []ColumnTransform{{Table: "public.simple", Column: "id", Transform: swapHex}},
+	}
+	if _, err := s.copyQuery("SELECT * FROM simple", "public.simple"); err != nil {
+		t.Fatal(err)
+	}
+	// Rows already copied with masked keys are not copied again
+	if err := (&Rule{Table: "public.simple", Where: RuleAll}).Copy(s); err != nil {
+		t.Fatalf("Rule.Copy() error = %v", err)
+	}
+	if count, _ := CountRows("simple", dst); count != 10 {
+		t.Errorf("CountRows(simple) = %v, want 10", count)
+	}
+}
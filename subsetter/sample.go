@@ -0,0 +1,155 @@
+package subsetter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// SampleStrategy selects how the number of rows to copy grows with the rows of a table.
+type SampleStrategy string
+
+const (
+	// SampleLog copies rows^fraction rows, large tables shrink much more than small ones.
+	SampleLog SampleStrategy = "log"
+	// SampleLinear copies the fraction of rows.
+	SampleLinear SampleStrategy = "linear"
+	// SampleFixed copies a fixed number of rows.
+	SampleFixed SampleStrategy = "fixed"
+)
+
+// SampleStrategies lists the supported sample strategies.
+var SampleStrategies = []SampleStrategy{SampleLog, SampleLinear, SampleFixed}
+
+// TableSample sets how many rows to copy, for tables matching a pattern when used as an
+// override. Zero values keep the value of the sync: Strategy selects the strategy, Fraction
+// the fraction for the log and linear strategies and Rows the number of rows of the fixed
+// strategy. Min and Max clamp the number of rows and All copies every row. Stratify spreads
+// the rows over the values of a column, PerGroup copying that many rows of every value instead.
+type TableSample struct {
+	Table    string
+	Strategy SampleStrategy
+	Fraction float64
+	Rows     int
+	Min      int
+	Max      int
+	All      bool
+	Stratify string
+	PerGroup int
+}
+
+// TargetRows returns the number of rows to copy out of rows, clamped to Min and Max.
+// Min never exceeds the rows of the table.
+func (ts TableSample) TargetRows(rows int) int {
+	if ts.All {
+		return rows
+	}
+
+	var target int
+	switch ts.Strategy {
+	case SampleLinear:
+		target = int(math.Round(float64(rows) * ts.Fraction))
+	case SampleFixed:
+		target = min(ts.Rows, rows)
+	default:
+		target = GetTargetSet(ts.Fraction, []Table{{Rows: rows}})[0].Rows
+	}
+
+	if ts.Min > 0 {
+		target = max(target, min(ts.Min, rows))
+	}
+	if ts.Max > 0 {
+		target = min(target, ts.Max)
+	}
+	return target
+}
+
+// String describes how rows are sampled, e.g. "linear 0.1, at least 100".
+func (ts TableSample) String() string {
+	if ts.All {
+		return "all rows"
+	}
+
+	var description []string
+	switch ts.Strategy {
+	case SampleFixed:
+		description = append(description, fmt.Sprintf("fixed %d", ts.Rows))
+	case SampleLinear:
+		description = append(description, fmt.Sprintf("linear %g", ts.Fraction))
+	default:
+		description = append(description, fmt.Sprintf("log %g", ts.Fraction))
+	}
+	if ts.Min > 0 {
+		description = append(description, fmt.Sprintf("at least %d", ts.Min))
+	}
+	if ts.Max > 0 {
+		description = append(description, fmt.Sprintf("at most %d", ts.Max))
+	}
+	if ts.Stratify != "" && ts.PerGroup > 0 {
+		description = append(description, fmt.Sprintf("stratified by %s, up to %d rows per value", ts.Stratify, ts.PerGroup))
+	} else if ts.Stratify != "" {
+		description = append(description, fmt.Sprintf("stratified by %s, at least a row per value", ts.Stratify))
+	}
+	return strings.Join(description, ", ")
+}
+
+// overrides reports whether the sample changes how many rows are copied.
+func (ts TableSample) overrides() bool {
+	return ts.Strategy != "" || ts.Fraction > 0 || ts.Rows > 0 || ts.Min > 0 || ts.Max > 0 || ts.All || ts.Stratify != ""
+}
+
+// sample returns the first sample override matching a table.
+func (s *Sync) sample(table string) (TableSample, bool) {
+	return lo.Find(s.samples, func(sample TableSample) bool {
+		return MatchTable(sample.Table, table)
+	})
+}
+
+// tableSample returns how rows of a table are sampled, the values of the first
+// matching override replacing those of the sync.
+func (s *Sync) tableSample(table string) TableSample {
+	sample := s.sampling
+	sample.Table = QualifiedName(table)
+	if sample.Fraction == 0 {
+		sample.Fraction = s.fraction
+	}
+
+	override, ok := s.sample(table)
+	if !ok {
+		return sample
+	}
+	if override.Strategy != "" {
+		sample.Strategy = override.Strategy
+	}
+	if override.Fraction > 0 {
+		sample.Fraction = override.Fraction
+	}
+	if override.Rows > 0 {
+		sample.Rows = override.Rows
+	}
+	if override.Min > 0 {
+		sample.Min = override.Min
+	}
+	if override.Max > 0 {
+		sample.Max = override.Max
+	}
+	if override.Stratify != "" {
+		sample.Stratify = override.Stratify
+		sample.PerGroup = override.PerGroup
+	}
+	sample.All = override.All
+	return sample
+}
+
+// withLimit reports whether the rows copied into a table are limited to its target.
+// Tables referencing copied rows take every referencing row, unless a sample override
+// sets how many rows they copy. Tables sampling all rows are never limited.
+func (s *Sync) withLimit(table Table, relatedQueries []string) bool {
+	sample, ok := s.sample(table.FullName())
+	if sample.All {
+		return false
+	}
+	return len(relatedQueries) == 0 || (ok && sample.overrides())
+}
@@ -0,0 +1,140 @@
+package subsetter
+
+import (
+	"testing"
+)
+
+func TestSync_targetSet(t *testing.T) {
+	s := &Sync{fraction: 0.5, samples: []TableSample{
+		{Table: "plans", All: true},
+		{Table: "events", Fraction: 0.25},
+		{Table: "audit_*", Max: 10},
+		{Table: "logs", Fraction: 1, Max: 500},
+	}}
+	tables := []Table{
+		{Schema: "public", Name: "plans", Rows: 10000},
+		{Schema: "public", Name: "events", Rows: 10000},
+		{Schema: "public", Name: "audit_log", Rows: 10000},
+		{Schema: "public", Name: "logs", Rows: 10000},
+		{Schema: "public", Name: "users", Rows: 10000},
+	}
+	want := []int{10000, 10, 10, 500, 100}
+	for i, table := range s.targetSet(tables) {
+		if table.Rows != want[i] {
+			t.Errorf("targetSet() %s = %v rows, want %v", table.FullName(), table.Rows, want[i])
+		}
+	}
+}
+
+func TestSync_withLimit(t *testing.T) {
+	s := &Sync{samples: []TableSample{{Table: "plans", All: true}, {Table: "audit_log", Max: 10}}}
+	related := []string{"user_id = ANY('{1}')"}
+	tests := []struct {
+		name    string
+		table   string
+		related []string
+		want    bool
+	}{
+		{"Root", "users", nil, true},
+		{"Referencing", "orders", related, false},
+		{"All", "plans", nil, false},
+		{"Referencing with maximum", "audit_log", related, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.withLimit(Table{Schema: "public", Name: tt.table}, tt.related); got != tt.want {
+				t.Errorf("Sync.withLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableSample_TargetRows(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample TableSample
+		rows   int
+		want   int
+	}{
+		{"Log", TableSample{Fraction: 0.5}, 1000000, 1000},
+		{"Linear", TableSample{Strategy: SampleLinear, Fraction: 0.5}, 1000000, 500000},
+		{"Fixed", TableSample{Strategy: SampleFixed, Rows: 100}, 1000000, 100},
+		{"Fixed above rows", TableSample{Strategy: SampleFixed, Rows: 100}, 10, 10},
+		{"Min", TableSample{Strategy: SampleLinear, Fraction: 0.01, Min: 50}, 1000, 50},
+		{"Min above rows", TableSample{Strategy: SampleLinear, Fraction: 0.01, Min: 50}, 20, 20},
+		{"Max", TableSample{Strategy: SampleLinear, Fraction: 0.5, Max: 100}, 1000, 100},
+		{"All", TableSample{All: true, Max: 10}, 1000, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sample.TargetRows(tt.rows); got != tt.want {
+				t.Errorf("TableSample.TargetRows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSync_tableSample(t *testing.T) {
+	s := &Sync{
+		fraction: 0.5,
+		sampling: TableSample{Strategy: SampleLinear, Min: 10},
+		samples:  []TableSample{{Table: "events", Fraction: 0.01, Max: 1000}, {Table: "plans", All: true}},
+	}
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{"users", "linear 0.5, at least 10"},
+		{"events", "linear 0.01, at least 10, at most 1000"},
+		{"plans", "all rows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			if got := s.tableSample(tt.table).String(); got != tt.want {
+				t.Errorf("Sync.tableSample() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableDataQuery(t *testing.T) {
+	table := Table{"public", "users", 10, nil, nil}
+	tests := []struct {
+		name      string
+		related   []string
+		withLimit bool
+		seed      string
+		want      string
+	}{
+		{"Without seed", nil, true, "", `SELECT * FROM "public"."users"   LIMIT 10`},
+		{"With seed", nil, true, "it's", `SELECT * FROM "public"."users"  order by md5('it''s' || CAST(ROW("public"."users".*) AS text)) LIMIT 10`},
+		{"With numeric seed", []string{`"id" > 1`}, true, "42", `SELECT * FROM "public"."users" WHERE "id" > 1 order by md5('42' || CAST(ROW("public"."users".*) AS text)) LIMIT 10`},
+		{"With seed without limit", []string{`"id" > 1`}, false, "42", `SELECT * FROM "public"."users" WHERE "id" > 1 order by random() `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Sync{seed: tt.seed}
+			if got := s.tableDataQuery(table, tt.related, tt.withLimit); got != tt.want {
+				t.Errorf("tableDataQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSync_stratifiedQuery(t *testing.T) {
+	s := &Sync{samples: []TableSample{{Table: "accounts", Stratify: "plan_id", PerGroup: 20}}}
+
+	want := `SELECT * FROM "public"."accounts" WHERE (tableoid, ctid) IN (SELECT tableoid, ctid FROM (SELECT tableoid, ctid, row_number() OVER (PARTITION BY "plan_id" ORDER BY random()) AS n, random() AS h FROM "public"."accounts" ) AS r WHERE n <= 20)`
+	if got := s.tableDataQuery(Table{"public", "accounts", 100, nil, nil}, nil, true); got != want {
+		t.Errorf("Sync.tableDataQuery() = %q, want %q", got, want)
+	}
+
+	s = &Sync{fraction: 0.5, seed: "secret", samples: []TableSample{{Table: "accounts", Stratify: "region"}}}
+	want = `SELECT * FROM "public"."accounts" WHERE (tableoid, ctid) IN (SELECT tableoid, ctid FROM (SELECT tableoid, ctid, row_number() OVER (PARTITION BY "region" ORDER BY md5('secret' || CAST(ROW("public"."accounts".*) AS text))) AS n, md5('secret' || CAST(ROW("public"."accounts".*) AS text)) AS h FROM "public"."accounts" WHERE "id" > 1) AS r ORDER BY n, h LIMIT GREATEST(100, (SELECT count(*) FROM (SELECT DISTINCT "region" FROM "public"."accounts" WHERE "id" > 1) AS v)))`
+	if got := s.tableDataQuery(Table{"public", "accounts", 100, nil, nil}, []string{`"id" > 1`}, true); got != want {
+		t.Errorf("Sync.tableDataQuery() = %q, want %q", got, want)
+	}
+	if got := s.tableSample("public.accounts").String(); got != "log 0.5, stratified by region, at least a row per value" {
+		t.Errorf("Sync.tableSample() = %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+package subsetter
+
+import (
+	"slices"
+
+	"github.com/samber/lo"
+)
+
+// schedule orders the copy of tables by their foreign keys, a table is ready
+// once all tables it references are finished. Tables not in the schedule and
+// self references never block a table. Postponed tables are copied after all
+// others, along with the tables referencing them.
+type schedule struct {
+	order      []string
+	references map[string][]string
+	started    map[string]bool
+	finished   map[string]bool
+	postponed  []string
+}
+
+// newSchedule builds the foreign key graph of tables.
+func newSchedule(tables []Table) *schedule {
+	names := lo.Map(tables, func(table Table, _ int) string { return table.FullName() })
+
+	references := map[string][]string{}
+	for _, table := range tables {
+		for _, relation := range table.Relations {
+			if relation.IsSelfRelated() || !slices.Contains(names, relation.ForeignTable) {
+				continue
+			}
+			if !slices.Contains(references[table.FullName()], relation.ForeignTable) {
+				references[table.FullName()] = append(references[table.FullName()], relation.ForeignTable)
+			}
+		}
+	}
+
+	return &schedule{
+		order:      names,
+		references: references,
+		started:    map[string]bool{},
+		finished:   map[string]bool{},
+	}
+}
+
+// waiting returns the unfinished tables referenced by a table.
+func (s *schedule) waiting(table string) []string {
+	return lo.Filter(s.references[table], func(reference string, _ int) bool {
+		return !s.finished[reference]
+	})
+}
+
+// next starts the first table that is ready to be copied. Ready tables referencing
+// postponed tables are postponed as well.
+func (s *schedule) next() (string, bool) {
+	for _, table := range s.order {
+		if !s.started[table] && len(s.waiting(table)) == 0 {
+			s.started[table] = true
+			if s.holdBack(table) {
+				continue
+			}
+			return table, true
+		}
+	}
+	return "", false
+}
+
+// breakCycle starts the table waiting on the fewest tables, used when tables
+// reference each other and none of them can become ready.
+func (s *schedule) breakCycle() (string, bool) {
+	for {
+		best, found := "", false
+		for _, table := range s.order {
+			if s.started[table] {
+				continue
+			}
+			if !found || len(s.waiting(table)) < len(s.waiting(best)) {
+				best, found = table, true
+			}
+		}
+		if !found {
+			return "", false
+		}
+		s.started[best] = true
+		if !s.holdBack(best) {
+			return best, true
+		}
+	}
+}
+
+// holdBack postpones a table referencing postponed tables, reporting whether it did.
+func (s *schedule) holdBack(table string) bool {
+	if !lo.SomeBy(s.references[table], func(reference string) bool { return slices.Contains(s.postponed, reference) }) {
+		return false
+	}
+	s.postpone(table)
+	return true
+}
+
+// postpone defers a table to after all other tables. Its dependents don't wait for it,
+// they are postponed as well when they become ready.
+func (s *schedule) postpone(table string) {
+	s.postponed = append(s.postponed, table)
+	s.finished[table] = true
+}
+
+// finish marks a table as copied, making the tables referencing it ready.
+func (s *schedule) finish(table string) {
+	s.finished[table] = true
+}
+
+// unfinished returns the tables that are not copied yet.
+func (s *schedule) unfinished() []string {
+	return lo.Filter(s.order, func(table string, _ int) bool {
+		return !s.finished[table]
+	})
+}
+
+// pending reports whether some tables are not started yet.
+func (s *schedule) pending() bool {
+	return len(s.started) < len(s.order)
+}
@@ -0,0 +1,97 @@
+package subsetter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchedule(t *testing.T) {
+	tables := []Table{
+		{"public", "orders", 10, []Relation{{"public.orders", []string{"user_id"}, "public.users", []string{"id"}}}, []Relation{}},
+		{"public", "users", 10, []Relation{}, []Relation{}},
+		{"public", "tags", 10, []Relation{{"public.tags", []string{"parent_id"}, "public.tags", []string{"id"}}}, []Relation{}},
+		{"public", "items", 10, []Relation{
+			{"public.items", []string{"order_id"}, "public.orders", []string{"id"}},
+			{"public.items", []string{"domain_id"}, "public.domains", []string{"id"}},
+		}, []Relation{}},
+	}
+	s := newSchedule(tables)
+
+	var started []string
+	for {
+		table, ok := s.next()
+		if !ok {
+			break
+		}
+		started = append(started, table)
+	}
+	if want := []string{"public.users", "public.tags"}; !reflect.DeepEqual(started, want) {
+		t.Fatalf("next() = %v, want %v", started, want)
+	}
+
+	s.finish("public.users")
+	if table, _ := s.next(); table != "public.orders" {
+		t.Fatalf("next() = %v, want public.orders", table)
+	}
+	if _, ok := s.next(); ok {
+		t.Fatal("next() started a table waiting on an unfinished table")
+	}
+
+	s.finish("public.orders")
+	if table, _ := s.next(); table != "public.items" {
+		t.Fatalf("next() = %v, want public.items", table)
+	}
+	if s.pending() {
+		t.Fatal("pending() = true, want false")
+	}
+}
+
+func TestSchedule_breakCycle(t *testing.T) {
+	tables := []Table{
+		{"public", "a", 10, []Relation{
+			{"public.a", []string{"b_id"}, "public.b", []string{"id"}},
+			{"public.a", []string{"c_id"}, "public.c", []string{"id"}},
+		}, []Relation{}},
+		{"public", "b", 10, []Relation{{"public.b", []string{"c_id"}, "public.c", []string{"id"}}}, []Relation{}},
+		{"public", "c", 10, []Relation{{"public.c", []string{"a_id"}, "public.a", []string{"id"}}}, []Relation{}},
+	}
+	s := newSchedule(tables)
+
+	if _, ok := s.next(); ok {
+		t.Fatal("next() started a table of a cycle")
+	}
+	if table, _ := s.breakCycle(); table != "public.b" {
+		t.Fatalf("breakCycle() = %v, want public.b", table)
+	}
+	s.finish("public.b")
+	if got, want := s.unfinished(), []string{"public.a", "public.c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unfinished() = %v, want %v", got, want)
+	}
+}
+
+func TestSchedule_postpone(t *testing.T) {
+	tables := []Table{
+		{"public", "users", 10, []Relation{}, []Relation{}},
+		{"public", "orders", 10, []Relation{{"public.orders", []string{"user_id"}, "public.users", []string{"id"}}}, []Relation{}},
+		{"public", "items", 10, []Relation{{"public.items", []string{"order_id"}, "public.orders", []string{"id"}}}, []Relation{}},
+		{"public", "domains", 10, []Relation{}, []Relation{}},
+	}
+	s := newSchedule(tables)
+
+	if table, _ := s.next(); table != "public.users" {
+		t.Fatalf("next() = %v, want public.users", table)
+	}
+	if table, _ := s.next(); table != "public.domains" {
+		t.Fatalf("next() = %v, want public.domains", table)
+	}
+	s.postpone("public.users")
+	if table, ok := s.next(); ok {
+		t.Fatalf("next() = %v, started a table referencing a postponed table", table)
+	}
+	if s.pending() {
+		t.Fatal("pending() = true, want false")
+	}
+	if want := []string{"public.users", "public.orders", "public.items"}; !reflect.DeepEqual(s.postponed, want) {
+		t.Fatalf("postponed = %v, want %v", s.postponed, want)
+	}
+}
@@ -0,0 +1,498 @@
+package subsetter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+// Schema holds the definitions of tables and the objects they depend on, read from the
+// catalog of a database. Names are schema qualified, definitions are SQL with schema
+// qualified names so they can be replayed on another database.
+type Schema struct {
+	Extensions  []Extension
+	Enums       []Enum
+	Sequences   []Sequence
+	Tables      []TableDefinition
+	Constraints []Constraint
+	Indexes     []Index
+}
+
+// Extension is an installed extension and the schema holding its objects.
+type Extension struct {
+	Name   string
+	Schema string
+}
+
+// Enum is an enum type and its labels in order.
+type Enum struct {
+	Name   string
+	Labels []string
+}
+
+// Sequence is a sequence, Last is the last value returned by the sequence if any.
+// Sequences of identity columns are created with their table. OwnedBy is the quoted
+// column owning the sequence.
+type Sequence struct {
+	Name      string
+	Type      string
+	Start     int64
+	Increment int64
+	Min       int64
+	Max       int64
+	Cycle     bool
+	Last      *int64
+	Identity  bool
+	OwnedBy   string
+}
+
+// TableDefinition is a table and its columns. Partitions list the parent table in
+// PartitionOf with the partition bound, partitioned tables their partition key.
+type TableDefinition struct {
+	Name        string
+	Columns     []ColumnDefinition
+	PartitionBy string
+	PartitionOf string
+	Bound       string
+}
+
+// ColumnDefinition is a column of a table. Identity is "a" or "d" for identity
+// columns generated always or by default, Generated the expression of stored
+// generated columns.
+type ColumnDefinition struct {
+	Name      string
+	Type      string
+	NotNull   bool
+	Default   string
+	Identity  string
+	Generated string
+}
+
+// Constraint is a table constraint, Type is its pg_constraint type.
+type Constraint struct {
+	Table      string
+	Name       string
+	Type       string
+	Definition string
+}
+
+// Index is an index of a table that doesn't back a constraint.
+type Index struct {
+	Table      string
+	Name       string
+	Definition string
+}
+
+// deferredStatement is a statement run once data is loaded and the table it alters.
+type deferredStatement struct {
+	table string
+	query string
+}
+
+// IsForeignKey reports whether the constraint is a foreign key, foreign keys are
+// added once data is loaded.
+func (c *Constraint) IsForeignKey() bool {
+	return c.Type == "f"
+}
+
+// Create returns the statement creating the enum.
+func (e *Enum) Create() string {
+	return fmt.Sprintf("CREATE TYPE %s AS ENUM (%s)", QuoteTable(e.Name), strings.Join(lo.Map(e.Labels, func(label string, _ int) string {
+		return QuoteLiteral(label)
+	}), ", "))
+}
+
+// Create returns the statement creating the sequence.
+func (sq *Sequence) Create() string {
+	cycle := "NO CYCLE"
+	if sq.Cycle {
+		cycle = "CYCLE"
+	}
+	return fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s AS %s INCREMENT BY %d MINVALUE %d MAXVALUE %d START WITH %d %s",
+		QuoteTable(sq.Name), sq.Type, sq.Increment, sq.Min, sq.Max, sq.Start, cycle)
+}
+
+// Create returns the statement creating the table without its constraints.
+func (t *TableDefinition) Create() string {
+	var q string
+	if t.PartitionOf != "" {
+		q = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s %s", QuoteTable(t.Name), QuoteTable(t.PartitionOf), t.Bound)
+	} else {
+		q = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", QuoteTable(t.Name), strings.Join(lo.Map(t.Columns, func(c ColumnDefinition, _ int) string {
+			return c.definition()
+		}), ", "))
+	}
+	if t.PartitionBy != "" {
+		q += " PARTITION BY " + t.PartitionBy
+	}
+	return q
+}
+
+// definition returns the column definition used in CREATE TABLE.
+func (c *ColumnDefinition) definition() string {
+	d := QuoteIdentifier(c.Name) + " " + c.Type
+	switch {
+	case c.Generated != "":
+		d += fmt.Sprintf(" GENERATED ALWAYS AS (%s) STORED", c.Generated)
+	case c.Identity == "a":
+		d += " GENERATED ALWAYS AS IDENTITY"
+	case c.Identity == "d":
+		d += " GENERATED BY DEFAULT AS IDENTITY"
+	case c.Default != "":
+		d += " DEFAULT " + c.Default
+	}
+	if c.NotNull {
+		d += " NOT NULL"
+	}
+	return d
+}
+
+// Create returns the statement adding the constraint to its table.
+func (c *Constraint) Create() string {
+	return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", QuoteTable(c.Table), QuoteIdentifier(c.Name), c.Definition)
+}
+
+// catalogNames holds the names of objects present in a database, keyed by kind and
+// qualified name, used to skip objects that already exist.
+type catalogNames map[string]bool
+
+func (n catalogNames) has(kind string, name string) bool {
+	return n[kind+":"+name]
+}
+
+// Statements returns the statements creating the objects of the schema. Without deferred
+// these are the objects needed to load data: schemas, extensions, types, sequences, tables
+// and their keys and checks. With deferred these are the foreign keys and indexes, created
+// once data is loaded.
+func (sc *Schema) Statements(deferred bool) []string {
+	return sc.statements(nil, deferred)
+}
+
+// statements returns the statements creating the objects of the schema that are not in existing.
+func (sc *Schema) statements(existing catalogNames, deferred bool) (statements []string) {
+	if deferred {
+		return lo.Map(sc.deferred(existing), func(d deferredStatement, _ int) string { return d.query })
+	}
+
+	for _, name := range sc.schemas() {
+		statements = append(statements, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdentifier(name)))
+	}
+	for _, e := range sc.Extensions {
+		statements = append(statements, fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s", QuoteIdentifier(e.Name), QuoteIdentifier(e.Schema)))
+	}
+	for _, e := range sc.Enums {
+		if !existing.has("type", e.Name) {
+			statements = append(statements, e.Create())
+		}
+	}
+	for _, sq := range sc.Sequences {
+		if !sq.Identity {
+			statements = append(statements, sq.Create())
+		}
+	}
+	for _, t := range sc.Tables {
+		statements = append(statements, t.Create())
+	}
+	for _, sq := range sc.Sequences {
+		if !sq.Identity && sq.OwnedBy != "" {
+			statements = append(statements, fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s", QuoteTable(sq.Name), sq.OwnedBy))
+		}
+	}
+	for _, c := range sc.Constraints {
+		if !c.IsForeignKey() && !existing.has("constraint", c.Table+"."+c.Name) {
+			statements = append(statements, c.Create())
+		}
+	}
+	return
+}
+
+// deferred returns the statements creating the foreign keys and indexes that are not in existing.
+func (sc *Schema) deferred(existing catalogNames) (statements []deferredStatement) {
+	for _, c := range sc.Constraints {
+		if c.IsForeignKey() && !existing.has("constraint", c.Table+"."+c.Name) {
+			statements = append(statements, deferredStatement{c.Table, c.Create()})
+		}
+	}
+	for _, index := range sc.Indexes {
+		if !existing.has("index", index.Name) {
+			statements = append(statements, deferredStatement{index.Table, index.Definition})
+		}
+	}
+	return
+}
+
+// schemas returns the names of the schemas holding objects of the schema.
+func (sc *Schema) schemas() []string {
+	names := []string{}
+	for _, t := range sc.Tables {
+		names = append(names, strings.SplitN(t.Name, ".", 2)[0])
+	}
+	for _, e := range sc.Enums {
+		names = append(names, strings.SplitN(e.Name, ".", 2)[0])
+	}
+	for _, sq := range sc.Sequences {
+		names = append(names, strings.SplitN(sq.Name, ".", 2)[0])
+	}
+	for _, e := range sc.Extensions {
+		names = append(names, e.Schema)
+	}
+	names = lo.Uniq(names)
+	slices.Sort(names)
+	return lo.Without(names, DefaultSchema)
+}
+
+// GetSchema reads the definitions of tables in schemas matching the patterns from the
+// catalog, along with the extensions, enums and sequences they may use. Functions,
+// views, triggers and other types are not read.
+func GetSchema(schemas []string, conn *pgxpool.Pool) (schema Schema, err error) {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Without user schemas in the search path, definitions use qualified names
+	if _, err = tx.Exec(ctx, "SET LOCAL search_path = pg_catalog"); err != nil {
+		return
+	}
+
+	matches := func(name string) bool {
+		return MatchSchema(schemas, strings.SplitN(name, ".", 2)[0])
+	}
+
+	if schema.Extensions, err = queryRows(tx, `SELECT e.extname, n.nspname
+	FROM pg_extension e
+	JOIN pg_namespace n ON n.oid = e.extnamespace
+	WHERE e.extname <> 'plpgsql' AND n.nspname <> 'pg_catalog'
+	ORDER BY e.oid`, func(row pgx.Rows) (e Extension, err error) {
+		err = row.Scan(&e.Name, &e.Schema)
+		return
+	}); err != nil {
+		return schema, errors.Wrap(err, "Error reading extensions")
+	}
+
+	if schema.Enums, err = queryRows(tx, `SELECT n.nspname || '.' || t.typname, array_agg(e.enumlabel::text ORDER BY e.enumsortorder)
+	FROM pg_type t
+	JOIN pg_namespace n ON n.oid = t.typnamespace
+	JOIN pg_enum e ON e.enumtypid = t.oid
+	WHERE NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = t.oid AND d.deptype = 'e')
+	GROUP BY n.nspname, t.typname
+	ORDER BY 1`, func(row pgx.Rows) (e Enum, err error) {
+		err = row.Scan(&e.Name, &e.Labels)
+		return
+	}); err != nil {
+		return schema, errors.Wrap(err, "Error reading enums")
+	}
+	schema.Enums = lo.Filter(schema.Enums, func(e Enum, _ int) bool { return matches(e.Name) })
+
+	if schema.Sequences, err = queryRows(tx, `SELECT
+		s.schemaname || '.' || s.sequencename,
+		s.data_type::text,
+		s.start_value, s.increment_by, s.min_value, s.max_value, s.cycle, s.last_value,
+		EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'i'),
+		coalesce((
+			SELECT format('%I.%I.%I', tn.nspname, t.relname, a.attname)
+			FROM pg_depend d
+			JOIN pg_class t ON t.oid = d.refobjid
+			JOIN pg_namespace tn ON tn.oid = t.relnamespace
+			JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+			WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'a'
+		), '')
+	FROM pg_sequences s
+	JOIN pg_namespace n ON n.nspname = s.schemaname
+	JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = s.sequencename
+	ORDER BY 1`, func(row pgx.Rows) (sq Sequence, err error) {
+		err = row.Scan(&sq.Name, &sq.Type, &sq.Start, &sq.Increment, &sq.Min, &sq.Max, &sq.Cycle, &sq.Last, &sq.Identity, &sq.OwnedBy)
+		return
+	}); err != nil {
+		return schema, errors.Wrap(err, "Error reading sequences")
+	}
+	schema.Sequences = lo.Filter(schema.Sequences, func(sq Sequence, _ int) bool { return matches(sq.Name) })
+
+	// Partitions follow the tables they belong to
+	if schema.Tables, err = queryRows(tx, `SELECT
+		n.nspname || '.' || c.relname,
+		CASE WHEN c.relkind = 'p' THEN pg_get_partkeydef(c.oid) ELSE '' END,
+		coalesce((
+			SELECT pn.nspname || '.' || pc.relname
+			FROM pg_inherits i
+			JOIN pg_class pc ON pc.oid = i.inhparent
+			JOIN pg_namespace pn ON pn.oid = pc.relnamespace
+			WHERE i.inhrelid = c.oid AND c.relispartition
+		), ''),
+		CASE WHEN c.relispartition THEN pg_get_expr(c.relpartbound, c.oid) ELSE '' END
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p')
+		AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		AND n.nspname NOT LIKE 'pg_toast%'
+		AND n.nspname NOT LIKE 'pg_temp%'
+		AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = c.oid AND d.deptype = 'e')
+	ORDER BY c.relispartition, c.oid`, func(row pgx.Rows) (t TableDefinition, err error) {
+		err = row.Scan(&t.Name, &t.PartitionBy, &t.PartitionOf, &t.Bound)
+		return
+	}); err != nil {
+		return schema, errors.Wrap(err, "Error reading tables")
+	}
+	schema.Tables = lo.Filter(schema.Tables, func(t TableDefinition, _ int) bool { return matches(t.Name) })
+
+	for i, t := range schema.Tables {
+		if schema.Tables[i].Columns, err = queryRows(tx, `SELECT
+			a.attname,
+			format_type(a.atttypid, a.atttypmod),
+			a.attnotnull,
+			coalesce(pg_get_expr(d.adbin, d.adrelid), ''),
+			a.attidentity::text,
+			a.attgenerated::text
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, func(row pgx.Rows) (c ColumnDefinition, err error) {
+			var generated string
+			err = row.Scan(&c.Name, &c.Type, &c.NotNull, &c.Default, &c.Identity, &generated)
+			if generated != "" {
+				c.Generated, c.Default = c.Default, ""
+			}
+			return
+		}, QuoteTable(t.Name)); err != nil {
+			return schema, errors.Wrapf(err, "Error reading columns of table %s", t.Name)
+		}
+	}
+
+	// Constraints of partitions inherited from their table are created with it
+	if schema.Constraints, err = queryRows(tx, `SELECT n.nspname || '.' || c.relname, con.conname, con.contype::text, pg_get_constraintdef(con.oid)
+	FROM pg_constraint con
+	JOIN pg_class c ON c.oid = con.conrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE con.contype IN ('p', 'u', 'c', 'x', 'f')
+		AND con.conparentid = 0
+		AND con.conislocal
+		AND c.relkind IN ('r', 'p')
+	ORDER BY n.nspname, c.relname, con.conname`, func(row pgx.Rows) (c Constraint, err error) {
+		err = row.Scan(&c.Table, &c.Name, &c.Type, &c.Definition)
+		return
+	}); err != nil {
+		return schema, errors.Wrap(err, "Error reading constraints")
+	}
+	schema.Constraints = lo.Filter(schema.Constraints, func(c Constraint, _ int) bool { return matches(c.Table) })
+
+	if schema.Indexes, err = queryRows(tx, `SELECT n.nspname || '.' || c.relname, n.nspname || '.' || ic.relname, pg_get_indexdef(i.indexrelid)
+	FROM pg_index i
+	JOIN pg_class ic ON ic.oid = i.indexrelid
+	JOIN pg_class c ON c.oid = i.indrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p')
+		AND NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = i.indexrelid AND con.contype IN ('p', 'u', 'x'))
+		AND NOT EXISTS (SELECT 1 FROM pg_inherits inh WHERE inh.inhrelid = i.indexrelid)
+	ORDER BY n.nspname, ic.relname`, func(row pgx.Rows) (index Index, err error) {
+		err = row.Scan(&index.Table, &index.Name, &index.Definition)
+		return
+	}); err != nil {
+		return schema, errors.Wrap(err, "Error reading indexes")
+	}
+	schema.Indexes = lo.Filter(schema.Indexes, func(index Index, _ int) bool { return matches(index.Table) })
+
+	return
+}
+
+// queryRows returns the rows of a query scanned with scan.
+func queryRows[T any](tx pgx.Tx, q string, scan func(pgx.Rows) (T, error), args ...any) (items []T, err error) {
+	rows, err := tx.Query(context.Background(), q, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// getCatalogNames returns the names of types, constraints and indexes in a database.
+func getCatalogNames(conn *pgxpool.Pool) (catalogNames, error) {
+	q := `SELECT 'type:' || n.nspname || '.' || t.typname
+	FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+	UNION ALL
+	SELECT 'constraint:' || n.nspname || '.' || c.relname || '.' || con.conname
+	FROM pg_constraint con JOIN pg_class c ON c.oid = con.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+	UNION ALL
+	SELECT 'index:' || n.nspname || '.' || c.relname
+	FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('i', 'I')`
+	rows, err := conn.Query(context.Background(), q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := catalogNames{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// createMissingSchema creates the objects of the source schema missing in the destination
+// that are needed to load data.
+func (s *Sync) createMissingSchema() (schema Schema, err error) {
+	if schema, err = GetSchema(s.schemas, s.source); err != nil {
+		return schema, errors.Wrap(err, "Error reading source schema")
+	}
+	existing, err := getCatalogNames(s.destination)
+	if err != nil {
+		return schema, errors.Wrap(err, "Error reading destination schema")
+	}
+
+	for _, q := range schema.statements(existing, false) {
+		log.Debug().Str("query", q).Msg("Creating schema")
+		if _, err = s.destination.Exec(context.Background(), q); err != nil {
+			return schema, errors.Wrapf(err, "Error creating schema: %s", q)
+		}
+	}
+	log.Info().Int("tables", len(schema.Tables)).Msg("Created schema")
+	return
+}
+
+// completeSchema creates the foreign keys and indexes of the source schema missing in
+// the destination and sets sequences to their value in the source. Foreign keys that
+// copied rows violate are reported as warnings.
+func (s *Sync) completeSchema(schema Schema) error {
+	existing, err := getCatalogNames(s.destination)
+	if err != nil {
+		return errors.Wrap(err, "Error reading destination schema")
+	}
+
+	for _, d := range schema.deferred(existing) {
+		log.Debug().Str("query", d.query).Msgf("Completing schema of table %s", d.table)
+		if _, err := s.destination.Exec(context.Background(), d.query); err != nil {
+			log.Warn().Err(err).Str("query", d.query).Msgf("Completing schema of table %s failed", d.table)
+			s.warn(d.table, fmt.Sprintf("Completing schema failed: %s", err))
+		}
+	}
+
+	for _, sq := range schema.Sequences {
+		if sq.Last == nil {
+			continue
+		}
+		// Sequences missing in the destination are skipped, setval ignores NULL
+		if _, err := s.destination.Exec(context.Background(), `SELECT setval(to_regclass($1), $2)`, QuoteTable(sq.Name), *sq.Last); err != nil {
+			return errors.Wrapf(err, "Error setting sequence %s", sq.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package subsetter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestTableDefinition_Create(t *testing.T) {
+	tests := []struct {
+		name  string
+		table TableDefinition
+		want  string
+	}{
+		{
+			"Columns",
+			TableDefinition{Name: "public.users", Columns: []ColumnDefinition{
+				{Name: "id", Type: "bigint", NotNull: true, Identity: "d"},
+				{Name: "email", Type: "text", Default: "''::text"},
+				{Name: "domain", Type: "text", Generated: "split_part(email, '@'::text, 2)"},
+			}},
+			`CREATE TABLE IF NOT EXISTS "public"."users" ("id" bigint GENERATED BY DEFAULT AS IDENTITY NOT NULL, "email" text DEFAULT ''::text, "domain" text GENERATED ALWAYS AS (split_part(email, '@'::text, 2)) STORED)`,
+		},
+		{
+			"Partitioned",
+			TableDefinition{Name: "public.events", Columns: []ColumnDefinition{{Name: "at", Type: "date"}}, PartitionBy: "RANGE (at)"},
+			`CREATE TABLE IF NOT EXISTS "public"."events" ("at" date) PARTITION BY RANGE (at)`,
+		},
+		{
+			"Partition",
+			TableDefinition{Name: "public.events_2024", PartitionOf: "public.events", Bound: "FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')"},
+			`CREATE TABLE IF NOT EXISTS "public"."events_2024" PARTITION OF "public"."events" FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.table.Create(); got != tt.want {
+				t.Errorf("TableDefinition.Create() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnum_Create(t *testing.T) {
+	enum := Enum{"public.priority", []string{"1", "2", "it's"}}
+	want := `CREATE TYPE "public"."priority" AS ENUM ('1', '2', 'it''s')`
+	if got := enum.Create(); got != want {
+		t.Errorf("Enum.Create() = %v, want %v", got, want)
+	}
+}
+
+func TestSchema_Statements(t *testing.T) {
+	last := int64(42)
+	schema := Schema{
+		Extensions: []Extension{{"pgcrypto", "public"}},
+		Enums:      []Enum{{"billing.state", []string{"open", "paid"}}},
+		Sequences: []Sequence{
+			{Name: "billing.invoice_number", Type: "bigint", Start: 1, Increment: 1, Min: 1, Max: 100, Last: &last, OwnedBy: `"billing"."invoices"."number"`},
+			{Name: "public.users_id_seq", Type: "bigint", Identity: true},
+		},
+		Tables: []TableDefinition{{Name: "billing.invoices", Columns: []ColumnDefinition{{Name: "number", Type: "bigint", Default: "nextval('billing.invoice_number'::regclass)"}}}},
+		Constraints: []Constraint{
+			{"billing.invoices", "invoices_pkey", "p", "PRIMARY KEY (number)"},
+			{"billing.invoices", "invoices_user_fk", "f", "FOREIGN KEY (user_id) REFERENCES public.users(id)"},
+		},
+		Indexes: []Index{{"billing.invoices", "billing.invoices_state_idx", "CREATE INDEX invoices_state_idx ON billing.invoices USING btree (state)"}},
+	}
+
+	want := []string{
+		`CREATE SCHEMA IF NOT EXISTS "billing"`,
+		`CREATE EXTENSION IF NOT EXISTS "pgcrypto" WITH SCHEMA "public"`,
+		`CREATE TYPE "billing"."state" AS ENUM ('open', 'paid')`,
+		`CREATE SEQUENCE IF NOT EXISTS "billing"."invoice_number" AS bigint INCREMENT BY 1 MINVALUE 1 MAXVALUE 100 START WITH 1 NO CYCLE`,
+		`CREATE TABLE IF NOT EXISTS "billing"."invoices" ("number" bigint DEFAULT nextval('billing.invoice_number'::regclass))`,
+		`ALTER SEQUENCE "billing"."invoice_number" OWNED BY "billing"."invoices"."number"`,
+		`ALTER TABLE "billing"."invoices" ADD CONSTRAINT "invoices_pkey" PRIMARY KEY (number)`,
+	}
+	if got := schema.Statements(false); !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema.Statements() = %#v, want %#v", got, want)
+	}
+
+	want = []string{
+		`ALTER TABLE "billing"."invoices" ADD CONSTRAINT "invoices_user_fk" FOREIGN KEY (user_id) REFERENCES public.users(id)`,
+		"CREATE INDEX invoices_state_idx ON billing.invoices USING btree (state)",
+	}
+	if got := schema.Statements(true); !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema.Statements() = %#v, want %#v", got, want)
+	}
+
+	existing := catalogNames{"type:billing.state": true, "constraint:billing.invoices.invoices_user_fk": true}
+	if got := schema.statements(existing, true); len(got) != 1 {
+		t.Errorf("Schema.statements() = %v, want only the index", got)
+	}
+}
+
+func TestSync_createMissingSchema(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	defer clearSchema(src)
+
+	populateTestsWithData(src, "simple", 10)
+
+	s := &Sync{source: src, destination: dst, createSchema: true}
+	schema, err := s.createMissingSchema()
+	if err != nil {
+		t.Fatalf("Sync.createMissingSchema() error = %v", err)
+	}
+	defer clearSchema(dst)
+
+	for _, table := range []string{"public.simple", "public.relation"} {
+		if _, err := CopyQueryToTable(TableQuery(table, "", ""), table, src, dst); err != nil {
+			t.Fatalf("CopyQueryToTable() error = %v", err)
+		}
+	}
+	if err := s.completeSchema(schema); err != nil {
+		t.Fatalf("Sync.completeSchema() error = %v", err)
+	}
+	if len(s.report.Tables) > 0 {
+		t.Errorf("Sync.completeSchema() report = %v, want no warnings", s.report.Tables)
+	}
+
+	var constraints int
+	if err := dst.QueryRow(context.Background(), `SELECT count(*) FROM pg_constraint WHERE conrelid = 'public.relation'::regclass`).Scan(&constraints); err != nil || constraints != 2 {
+		t.Errorf("constraints of relation = %v, want the primary and foreign key", constraints)
+	}
+}
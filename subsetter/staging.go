@@ -0,0 +1,130 @@
+package subsetter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+// stagingTable returns the name of the temporary table staging the keys of a relation.
+func stagingTable(i int) string {
+	return pgx.Identifier{"pg_temp", fmt.Sprintf("subsetter_keys_%d", i)}.Sanitize()
+}
+
+// stagingColumns returns the column names of a staging table holding n columns.
+func stagingColumns(n int) []string {
+	return lo.Times(n, func(i int) string { return fmt.Sprintf("c%d", i+1) })
+}
+
+// stagedPredicate returns the predicate matching columns against keys in a staging table.
+func stagedPredicate(columns []string, staging string) string {
+	return fmt.Sprintf(`%s IN (SELECT %s FROM %s)`, rowValue(QuoteColumns(columns)), strings.Join(stagingColumns(len(columns)), ", "), staging)
+}
+
+// copyTableStaged copies the rows of a table referencing rows already in the destination.
+// Referenced keys are streamed from the destination into temporary tables on the source,
+// so rows are selected with semi-joins instead of lists of keys.
+func (s *Sync) copyTableStaged(table Table, unfinished []string) (err error) {
+	ctx := context.Background()
+	src, err := s.source.Acquire(ctx)
+	if err != nil {
+		return
+	}
+	defer src.Release()
+	// Temporary tables live as long as the connection, drop them before it returns to the pool
+	defer func() {
+		_, _ = src.Exec(ctx, "DISCARD TEMP")
+	}()
+
+	relatedQueries := []string{}
+	for i, relation := range table.Relations {
+		if relation.IsSelfRelated() || slices.Contains(unfinished, relation.ForeignTable) {
+			continue
+		}
+		staging := stagingTable(i)
+		if err = s.stageKeys(src, staging, table, relation); err != nil {
+			return errors.Wrapf(err, "Error staging keys of %s for %s", relation.ForeignTable, table.FullName())
+		}
+		relatedQueries = append(relatedQueries, stagedPredicate(relation.PrimaryColumns, staging))
+	}
+
+	q := s.tableDataQuery(table, relatedQueries, s.withLimit(table, relatedQueries))
+	log.Debug().Str("query", q).Msgf("Copying table %s", table.FullName())
+
+	options, err := s.copyOptions(table.FullName())
+	if err != nil {
+		return
+	}
+	stats, err := copyConnQueryToTable(q, table.FullName(), options, src.Conn().PgConn(), s.destination)
+	if err != nil {
+		return errors.Wrapf(err, "Error copying table %s", table.FullName())
+	}
+	s.recordCopy(table.FullName(), stats)
+	return s.saveKeys(options.keys)
+}
+
+// stageKeys creates a temporary table on a source connection and copies the keys of the
+// foreign table referenced by the relation into it from the destination.
+func (s *Sync) stageKeys(src *pgxpool.Conn, staging string, table Table, relation Relation) error {
+	ctx := context.Background()
+
+	types, err := GetColumnTypes(table.FullName(), relation.PrimaryColumns, s.source)
+	if err != nil {
+		return err
+	}
+	columns := stagingColumns(len(types))
+	definitions := lo.Map(types, func(typ string, i int) string { return columns[i] + " " + typ })
+	if _, err = src.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (%s)", staging, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+
+	var keys io.Reader
+	switch {
+	case s.isTracked(relation.ForeignTable):
+		// Keys copied during this sync are read from the key store
+		keys = keysReader(s.keys, relation.ForeignTable, relation.ForeignColumns)
+	case s.keysMasked(relation.ForeignTable, relation.ForeignColumns):
+		// Masked keys of the destination are mapped to those of the source first
+		unmasked, err := s.unmaskedDestinationKeys(relation.ForeignTable, relation.ForeignColumns, "")
+		if err != nil {
+			return err
+		}
+		store := NewMemoryKeyStore()
+		if err = store.Add(relation.ForeignTable, relation.ForeignColumns, unmasked); err != nil {
+			return err
+		}
+		keys = keysReader(store, relation.ForeignTable, relation.ForeignColumns)
+	}
+
+	if keys != nil {
+		tag, err := src.Conn().PgConn().CopyFrom(ctx, keys, fmt.Sprintf(`copy %s from stdin`, staging))
+		if err != nil {
+			return err
+		}
+		log.Debug().Int64("rows", tag.RowsAffected()).Str("table", relation.ForeignTable).Msgf("Staged keys for %s", table.FullName())
+	} else {
+		dst, err := s.destination.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer dst.Release()
+
+		q := keysQuery(relation.ForeignTable, QuoteColumns(relation.ForeignColumns))
+		stats, err := pipeCopy(dst.Conn().PgConn(), fmt.Sprintf(`copy (%s) to stdout`, q), src.Conn().PgConn(), fmt.Sprintf(`copy %s from stdin`, staging), nil, nil, table.FullName())
+		if err != nil {
+			return err
+		}
+		log.Debug().Int64("rows", stats.Rows).Str("table", relation.ForeignTable).Msgf("Staged keys for %s", table.FullName())
+	}
+
+	_, err = src.Exec(ctx, "ANALYZE "+staging)
+	return err
+}
@@ -0,0 +1,39 @@
+package subsetter
+
+import "testing"
+
+func TestStagedPredicate(t *testing.T) {
+	want := `("order_id", "shop_id") IN (SELECT c1, c2 FROM "pg_temp"."subsetter_keys_1")`
+	if got := stagedPredicate([]string{"order_id", "shop_id"}, stagingTable(1)); got != want {
+		t.Errorf("stagedPredicate() = %v, want %v", got, want)
+	}
+}
+
+func TestSync_copyTableStaged(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	initSchema(dst)
+	defer clearSchema(src)
+	defer clearSchema(dst)
+
+	populateTestsWithData(src, "simple", 100)
+
+	s := &Sync{source: src, destination: dst, keyStaging: true}
+	tables, err := GetTablesWithRows(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	simple, relation := TableByName(tables, "simple"), TableByName(tables, "relation")
+	simple.Rows = 10
+
+	if err := s.copyTable(simple, nil); err != nil {
+		t.Fatalf("Sync.copyTable() error = %v", err)
+	}
+	if err := s.copyTable(relation, nil); err != nil {
+		t.Fatalf("Sync.copyTable() error = %v", err)
+	}
+	if count, _ := CountRows("relation", dst); count != 10 {
+		t.Errorf("CountRows() = %v, want 10 rows referencing copied rows", count)
+	}
+}
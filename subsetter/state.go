@@ -0,0 +1,83 @@
+package subsetter
+
+import (
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// stateBucket holds the finished steps of tables in a state file.
+const stateBucket = "\x00steps"
+
+// Steps of copying a table recorded in a state file.
+const (
+	stepData    = "data"
+	stepInclude = "include"
+)
+
+// State is a checkpoint of a sync kept in a file: the steps finished for each table and
+// the keys of copied rows. A sync using the state of an interrupted sync skips the
+// finished steps. State is a KeyStore.
+type State struct {
+	boltKeyStore
+}
+
+// OpenState opens a state file. Unless resuming, the state of earlier syncs is cleared.
+func OpenState(path string, resume bool) (*State, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening state %s", path)
+	}
+	state := &State{boltKeyStore{db: db}}
+
+	if !resume {
+		if err = clearBuckets(db); err != nil {
+			db.Close()
+			return nil, errors.Wrapf(err, "Error clearing state %s", path)
+		}
+	}
+	return state, nil
+}
+
+// stepKey returns the key of a step of a table in the state file.
+func stepKey(table string, step string) []byte {
+	return []byte(QualifiedName(table) + keySeparator + step)
+}
+
+// Finished reports whether a step of a table was finished.
+func (st *State) Finished(table string, step string) (finished bool, err error) {
+	err = st.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(stateBucket)); bucket != nil {
+			finished = bucket.Get(stepKey(table, step)) != nil
+		}
+		return nil
+	})
+	return
+}
+
+// Finish records that a step of a table is finished.
+func (st *State) Finish(table string, step string) error {
+	return st.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(stateBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(stepKey(table, step), []byte{})
+	})
+}
+
+// finished reports whether a step of a table was finished by an earlier sync.
+func (s *Sync) finished(table string, step string) (bool, error) {
+	if s.state == nil {
+		return false, nil
+	}
+	finished, err := s.state.Finished(table, step)
+	return finished, errors.Wrapf(err, "Error reading state of table %s", table)
+}
+
+// finish records that a step of a table is finished.
+func (s *Sync) finish(table string, step string) error {
+	if s.state == nil {
+		return nil
+	}
+	return errors.Wrapf(s.state.Finish(table, step), "Error saving state of table %s", table)
+}
@@ -0,0 +1,80 @@
+package subsetter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.state")
+
+	state, err := OpenState(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Finish("users", stepData); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Add("users", []string{"id"}, [][]string{{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	state.Close()
+
+	state, err = OpenState(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished, _ := state.Finished("public.users", stepData); !finished {
+		t.Error("State.Finished() = false after resuming, want true")
+	}
+	if finished, _ := state.Finished("users", stepInclude); finished {
+		t.Error("State.Finished() = true for an unfinished step, want false")
+	}
+	if keys, _ := storedKeys(state, "users", []string{"id"}); len(keys) != 1 {
+		t.Errorf("storedKeys() = %v after resuming, want 1 key", keys)
+	}
+	state.Close()
+
+	state, err = OpenState(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if finished, _ := state.Finished("users", stepData); finished {
+		t.Error("State.Finished() = true without resuming, want false")
+	}
+	if keys, _ := storedKeys(state, "users", []string{"id"}); len(keys) != 0 {
+		t.Errorf("storedKeys() = %v without resuming, want no keys", keys)
+	}
+}
+
+func TestSync_CopyTables_resume(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	initSchema(dst)
+	defer clearSchema(src)
+	defer clearSchema(dst)
+
+	populateTestsWithData(src, "simple", 100)
+
+	state, err := OpenState(filepath.Join(t.TempDir(), "sync.state"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if err := state.Finish("simple", stepData); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Sync{source: src, destination: dst, state: state, keys: state}
+	if err := s.CopyTables([]Table{{"public", "simple", 10, []Relation{}, []Relation{}}}); err != nil {
+		t.Fatalf("Sync.CopyTables() error = %v", err)
+	}
+	if count, _ := CountRows("simple", dst); count != 0 {
+		t.Errorf("CountRows() = %v, want rows copied by an earlier sync to be skipped", count)
+	}
+	if report := s.Report(); !report.Tables[0].Skipped {
+		t.Errorf("Sync.Report() = %v, want the table to be skipped", report.Tables)
+	}
+}
@@ -0,0 +1,199 @@
+package subsetter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// progressInterval is how often progress of a running copy is logged.
+var progressInterval = 10 * time.Second
+
+// CopyStats counts the rows and bytes streamed by a copy.
+type CopyStats struct {
+	Rows  int64
+	Bytes int64
+}
+
+// copyCounter counts rows and bytes in the COPY text format written through it.
+type copyCounter struct {
+	w     io.Writer
+	rows  atomic.Int64
+	bytes atomic.Int64
+}
+
+func (c *copyCounter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.bytes.Add(int64(n))
+	for _, b := range p[:n] {
+		if b == '\n' {
+			c.rows.Add(1)
+		}
+	}
+	return
+}
+
+// Stats returns the rows and bytes counted so far.
+func (c *copyCounter) Stats() CopyStats {
+	return CopyStats{Rows: c.rows.Load(), Bytes: c.bytes.Load()}
+}
+
+// CopyQueryToTable streams the rows of a query in the source database into a table
+// in the destination database. Rows are piped from COPY TO into COPY FROM, so only
+// the chunk in flight is held in memory.
+func CopyQueryToTable(query string, table string, source *pgxpool.Pool, destination *pgxpool.Pool) (CopyStats, error) {
+	return copyQueryToTable(query, table, copyOptions{}, source, destination)
+}
+
+// copyQuery streams the rows of a query into a table, masking its columns on the way
+// and recording the keys of copied rows.
+func (s *Sync) copyQuery(query string, table string) (stats CopyStats, err error) {
+	options, err := s.copyOptions(table)
+	if err != nil {
+		return
+	}
+	if stats, err = copyQueryToTable(query, table, options, s.source, s.destination); err != nil {
+		return
+	}
+	if err = s.saveKeys(options.keys); err != nil {
+		return
+	}
+	log.Debug().Int64("rows", stats.Rows).Int64("bytes", stats.Bytes).Msgf("Copied rows into %s", table)
+	s.recordCopy(table, stats)
+	return
+}
+
+// copyOptions are the optional steps of a copy: masking columns, recording keys, loading
+// rows through a staging table and copying only some columns.
+type copyOptions struct {
+	masker  *masker
+	keys    *keyRecorder
+	load    LoadMode
+	columns []string
+}
+
+// copyOptions returns the options copying rows into a table.
+func (s *Sync) copyOptions(table string) (options copyOptions, err error) {
+	if options.masker, err = s.masker(table); err != nil {
+		return
+	}
+	if options.keys, err = s.keyRecorder(table); err != nil {
+		return
+	}
+	options.load = s.loadMode
+	options.columns = s.columns[QualifiedName(table)]
+	return
+}
+
+// copyQueryToTable streams the rows of a query into a table.
+func copyQueryToTable(query string, table string, options copyOptions, source *pgxpool.Pool, destination *pgxpool.Pool) (stats CopyStats, err error) {
+	src, err := source.Acquire(context.Background())
+	if err != nil {
+		return
+	}
+	defer src.Release()
+	return copyConnQueryToTable(query, table, options, src.Conn().PgConn(), destination)
+}
+
+// copyConnQueryToTable streams the rows of a query on a source connection into a table,
+// for queries that depend on the session such as those reading temporary tables.
+func copyConnQueryToTable(query string, table string, options copyOptions, src *pgconn.PgConn, destination *pgxpool.Pool) (stats CopyStats, err error) {
+	copyTo := fmt.Sprintf(`copy (%s) to stdout`, columnsQuery(query, options.columns))
+	if options.load == "" || options.load == LoadCopy {
+		dst, err := destination.Acquire(context.Background())
+		if err != nil {
+			return stats, err
+		}
+		defer dst.Release()
+		return pipeCopy(src, copyTo, dst.Conn().PgConn(), copyFromStatement(QuoteTable(table), options.columns), options.masker, options.keys, table)
+	}
+
+	load, err := loadQuery(table, options.load, options.columns, destination)
+	if err != nil {
+		return
+	}
+	dst, err := destination.Acquire(context.Background())
+	if err != nil {
+		return
+	}
+	defer dst.Release()
+
+	loaded, err := stagedLoad(dst, table, options.columns, load, func(copyFrom string) (err error) {
+		stats, err = pipeCopy(src, copyTo, dst.Conn().PgConn(), copyFrom, options.masker, options.keys, table)
+		return
+	})
+	if err == nil && loaded < stats.Rows {
+		log.Info().Int64("rows", stats.Rows-loaded).Msgf("Skipped existing rows of %s", table)
+	}
+	return
+}
+
+// copyWriter chains the key recorder and the masker in front of w, the recorder sees rows
+// before they are masked as keys filter rows of the source. The mask writer is returned
+// to be flushed, nil without a masker.
+func copyWriter(w io.Writer, m *masker, rec *keyRecorder) (io.Writer, *maskWriter) {
+	var mw *maskWriter
+	if m != nil {
+		mw = &maskWriter{m: m, w: w}
+		w = mw
+	}
+	if rec != nil {
+		rec.w = w
+		w = rec
+	}
+	return w, mw
+}
+
+// pipeCopy streams the output of a COPY TO statement on one connection into a COPY FROM
+// statement on another, the masker and key recorder are optional. Keys are recorded
+// from the rows of the source, before masking.
+func pipeCopy(from *pgconn.PgConn, copyTo string, to *pgconn.PgConn, copyFrom string, m *masker, rec *keyRecorder, table string) (CopyStats, error) {
+	ctx := context.Background()
+
+	reader, writer := io.Pipe()
+	counter := &copyCounter{w: writer}
+	w, mw := copyWriter(counter, m, rec)
+
+	copied := make(chan error, 1)
+	go func() {
+		_, err := from.CopyTo(ctx, w, copyTo)
+		if err == nil && mw != nil {
+			err = mw.Flush()
+		}
+		// Closing with a nil error ends the stream for the reading side
+		_ = writer.CloseWithError(err)
+		copied <- err
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				stats := counter.Stats()
+				log.Info().Int64("rows", stats.Rows).Int64("bytes", stats.Bytes).Msgf("Copying %s", table)
+			}
+		}
+	}()
+
+	_, err := to.CopyFrom(ctx, reader, copyFrom)
+	// Unblock the writing side when the destination gave up early
+	_ = reader.CloseWithError(io.ErrClosedPipe)
+	// Report the source error when reading failed first, the destination error otherwise
+	if copyErr := <-copied; copyErr != nil && (err == nil || !errors.Is(copyErr, io.ErrClosedPipe)) {
+		err = copyErr
+	}
+	return counter.Stats(), err
+}
@@ -0,0 +1,75 @@
+package subsetter
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCopyQueryToTable(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	initSchema(dst)
+	defer clearSchema(src)
+	defer clearSchema(dst)
+
+	populateTestsWithData(src, "simple", 10)
+
+	stats, err := CopyQueryToTable(TableQuery("simple", "LIMIT 5", ""), "simple", src, dst)
+	if err != nil {
+		t.Fatalf("CopyQueryToTable() error = %v", err)
+	}
+	if stats.Rows != 5 || stats.Bytes == 0 {
+		t.Errorf("CopyQueryToTable() = %+v, want 5 rows", stats)
+	}
+	if count, _ := CountRows("simple", dst); count != 5 {
+		t.Errorf("CopyQueryToTable() copied %v rows, want 5", count)
+	}
+}
+
+func TestCopyCounter(t *testing.T) {
+	var buff bytes.Buffer
+	c := &copyCounter{w: &buff}
+	_, _ = c.Write([]byte("1\ttest\n2\tte"))
+	_, _ = c.Write([]byte("st\n"))
+
+	if got := c.Stats(); got.Rows != 2 || got.Bytes != 14 {
+		t.Errorf("copyCounter.Stats() = %+v, want 2 rows and 14 bytes", got)
+	}
+}
+
+func TestMaskWriter(t *testing.T) {
+	var buff bytes.Buffer
+	mw := &maskWriter{m: &masker{transforms: map[int]Transform{1: Fixed("x")}}, w: &buff}
+
+	_, _ = mw.Write([]byte("1\tjohn\n2\tja"))
+	if got := buff.String(); got != "1\tx\n" {
+		t.Errorf("maskWriter.Write() = %q, want complete rows only", got)
+	}
+	_, _ = mw.Write([]byte("ne\n3\tjoe"))
+	if err := mw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buff.String(); got != "1\tx\n2\tx\n3\tx" {
+		t.Errorf("maskWriter.Flush() = %q", got)
+	}
+}
+
+func TestCopyWriter(t *testing.T) {
+	var buff bytes.Buffer
+	rec := newKeyRecorder(NewMemoryKeyStore(), "public.users", []keyColumns{{columns: []string{"id"}, indexes: []int{0}}})
+	w, mw := copyWriter(&buff, &masker{transforms: map[int]Transform{0: Fixed("0"), 1: Fixed("x")}}, rec)
+
+	_, _ = w.Write([]byte("1\tjohn\n2\tja"))
+	_, _ = w.Write([]byte("ne\n"))
+	if err := mw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buff.String(); got != "0\tx\n0\tx\n" {
+		t.Errorf("copyWriter() wrote %q", got)
+	}
+	if want := [][][]string{{{"1"}, {"2"}}}; !reflect.DeepEqual(rec.keys, want) {
+		t.Errorf("copyWriter() recorded %v, want %v", rec.keys, want)
+	}
+}
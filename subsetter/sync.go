@@ -3,6 +3,8 @@ package subsetter
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pkg/errors"
@@ -19,198 +21,360 @@ func (se *SyncError) Error() string {
 }
 
 type Sync struct {
-	source      *pgxpool.Pool
-	destination *pgxpool.Pool
-	fraction    float64
-	verbose     bool
-	include     []Rule
-	exclude     []Rule
+	source            *pgxpool.Pool
+	destination       *pgxpool.Pool
+	fraction          float64
+	sampling          TableSample
+	samples           []TableSample
+	sourceRows        map[string]int
+	tableSampling     TableSampleMethod
+	tableSamplingRows int
+	verbose           bool
+	seed              string
+	transforms        []ColumnTransform
+	schemas           []string
+	include           []Rule
+	exclude           []Rule
+	jobs              int
+	keyStaging        bool
+	loadMode          LoadMode
+	truncate          bool
+	createSchema      bool
+	schemaCheck       SchemaCheck
+	columns           map[string][]string
+	cascade           bool
+	keys              KeyStore
+	state             *State
+	tracked           map[string]bool
+	trackedMutex      sync.RWMutex
+	report            Report
+	reportMutex       sync.Mutex
 }
 
-func NewSync(source string, target string, fraction float64, include []Rule, exclude []Rule, verbose bool) (*Sync, error) {
-	src, err := pgxpool.New(context.Background(), source)
-	if err != nil {
-		return nil, err
+// DefaultFraction is the fraction of rows copied when none is configured.
+const DefaultFraction = 0.05
+
+// NewSync connects to the source and destination databases and configures a sync with options.
+// Without a destination only Plan can be used, without a source only Verify.
+func NewSync(source string, target string, options ...Option) (*Sync, error) {
+	s := &Sync{
+		fraction: DefaultFraction,
+		jobs:     1,
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.keys == nil {
+		s.keys = NewMemoryKeyStore()
+	}
+
+	var err error
+	if source != "" {
+		if s.source, err = s.connect(source); err != nil {
+			return nil, err
+		}
+	}
+	if target != "" {
+		if s.destination, err = s.connect(target); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	if s.truncate && s.source != nil && s.destination != nil {
+		same, err := SameDatabase(s.source, s.destination)
+		if err == nil && same {
+			err = errors.New("Refusing to truncate tables of the source database")
+		}
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
 	}
+	return s, nil
+}
 
-	err = src.Ping(context.Background())
+// connect opens a connection pool large enough for the configured number of jobs.
+func (s *Sync) connect(dsn string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
+	// Each job holds a connection while copying and needs another one for queries
+	config.MaxConns = max(config.MaxConns, int32(2*s.jobs))
 
-	dst, err := pgxpool.New(context.Background(), target)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		return nil, err
 	}
-	err = dst.Ping(context.Background())
-	if err != nil {
+	if err = pool.Ping(context.Background()); err != nil {
+		pool.Close()
 		return nil, err
 	}
-
-	return &Sync{
-		source:      src,
-		destination: dst,
-		fraction:    fraction,
-		verbose:     verbose,
-		include:     include,
-		exclude:     exclude,
-	}, nil
+	return pool, nil
 }
 
 // Close closes the connections to the source and destination databases
 func (s *Sync) Close() {
-	s.source.Close()
-	s.destination.Close()
+	if s.source != nil {
+		s.source.Close()
+	}
+	if s.destination != nil {
+		s.destination.Close()
+	}
+	if s.keys != nil {
+		if err := s.keys.Close(); err != nil {
+			log.Error().Err(err).Msg("Error closing key store")
+		}
+	}
 }
 
-// CopyTables copies the data from a list of tables in the source database to the destination database
+// CopyTables copies the data from a list of tables in the source database to the destination database.
+// Tables are copied once all tables they reference are copied, up to the configured number of jobs at once.
+// Tables failing to copy are retried after all others, the tables referencing them are held back until then.
 func (s *Sync) CopyTables(tables []Table) (err error) {
+	type result struct {
+		table string
+		retry bool
+		err   error
+	}
 
-	// Filter out tables that are in include list and have custom rule
-	customRuleTables := lo.Uniq(lo.Map(s.include, func(rule Rule, _ int) string {
-		return rule.Table
-	}))
-
-	visitedTables := []string{}
-
-	// Copy tables without relations first
-	for _, table := range lo.Filter(tables, func(table Table, _ int) bool {
-		return len(table.Relations) == 0
-	}) {
-		log.Info().Str("table", table.Name).Msg("Transferring")
-		if !lo.Contains(customRuleTables, table.Name) {
-			if err = copyTableData(table, []string{}, true, s.source, s.destination); err != nil {
-				return errors.Wrapf(err, "Error copying table %s", table.Name)
-			}
-		} else {
-			for _, include := range s.include {
-				if include.Table == table.Name {
-					err = include.Copy(s)
-					if err != nil {
-						return errors.Wrapf(err, "Error copying forced rows for table %s", table.Name)
-					}
-					if include.Where != RuleAll {
-						// reverse copy all related rows
-						requiredTables, _ := RequiredTableGraph(table.Name, table.RequiredBy)
-						for _, relation := range requiredTables {
-							if relation == table.Name { // skip self
-								continue
-							}
-							relatedTable := TableByName(tables, relation)
-							if relatedTable.Name == "" { // skip unresolvable tables
-								continue
-							}
-							err := include.CopyRelated(s, relatedTable)
-							if err != nil {
-								log.Warn().Str("table", relatedTable.Name).Msgf("No rows found for related table")
-							}
-						}
-					}
+	jobs := max(s.jobs, 1)
+	s.track(tables)
+	schedule := newSchedule(tables)
+	results := make(chan result)
+	running := 0
+	maybeRetry := map[string]bool{}
+
+	for {
+		for err == nil && running < jobs {
+			name, ok := schedule.next()
+			if !ok && running == 0 && schedule.pending() {
+				// Tables referencing each other can't wait for one another
+				if name, ok = schedule.breakCycle(); ok {
+					log.Warn().Str("table", name).Strs("waiting", schedule.waiting(name)).Msg("Breaking foreign key cycle")
+					s.warn(name, "Breaking foreign key cycle")
 				}
 			}
+			if !ok {
+				break
+			}
+
+			running++
+			go func(table Table, unfinished []string) {
+				var retry bool
+				err := s.timed(table.FullName(), func() (err error) {
+					retry, err = s.transfer(table, unfinished)
+					return
+				})
+				results <- result{table: table.FullName(), retry: retry, err: err}
+			}(TableByName(tables, name), schedule.unfinished())
+		}
+		if running == 0 {
+			break
 		}
 
-		visitedTables = append(visitedTables, table.Name)
+		r := <-results
+		running--
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+		if r.retry {
+			// Tables referencing it wait for the retry as well
+			maybeRetry[r.table] = true
+			schedule.postpone(r.table)
+		} else {
+			schedule.finish(r.table)
+		}
+	}
+	if err != nil {
+		return
 	}
 
-	// Prevent infinite loop, by setting max depth
-	depth := 0
-	// Copy tables with relations
-	maybeRetry := []Table{}
-
-	for _, complexTable := range lo.Filter(tables, func(table Table, _ int) bool {
-		return len(table.Relations) > 0
-	}) {
-		log.Info().Str("table", complexTable.Name).Msg("Transferring")
-		if err := relationalCopy(&depth, tables, complexTable, &visitedTables, s.source, s.destination); err != nil {
-			log.Info().Str("table", complexTable.Name).Msgf("Transferring failed, retrying later")
-			maybeRetry = append(maybeRetry, complexTable)
+	// Retry tables with relations, then copy the tables referencing them
+	failed := map[string]bool{}
+	for _, name := range schedule.postponed {
+		retiredTable := TableByName(tables, name)
+		if waiting := lo.Filter(schedule.references[name], func(reference string, _ int) bool { return failed[reference] }); len(waiting) > 0 {
+			log.Warn().Str("table", name).Strs("failed", waiting).Msg("Skipping table referencing tables that failed to copy")
+			s.warn(name, "Skipping table referencing tables that failed to copy")
+			failed[name] = true
+			continue
 		}
 
-		for _, include := range s.include {
-			if include.Table == complexTable.Name {
-				// Copy only primary row by first setting ignore relational checks
-				_, err := s.destination.Exec(context.Background(), fmt.Sprintf("ALTER TABLE %s DISABLE TRIGGER USER;", complexTable.Name))
-				if err != nil {
-					return errors.Wrap(err, "Error setting session_replication_role to replica")
-				}
-
-				err = include.Copy(s)
-				if err != nil {
-					return errors.Wrapf(err, "Error copying forced rows for table %s", complexTable.Name)
-				}
-
-				// Set relational checks back
-				_, err = s.destination.Exec(context.Background(), fmt.Sprintf("ALTER TABLE %s ENABLE TRIGGER USER;", complexTable.Name))
-				if err != nil {
-					return errors.Wrap(err, "Error setting session_replication_role to origin")
-				}
+		var retry bool
+		if maybeRetry[name] {
+			log.Info().Str("table", name).Msg("Transferring")
+			s.record(name, func(t *TableReport) { t.Retries++ })
+			if copyErr := s.timed(name, func() error { return s.copyTable(retiredTable, nil) }); copyErr != nil {
+				retry = true
+			} else if err = s.finish(name, stepData); err != nil {
+				return err
 			}
+		} else if err = s.timed(name, func() (err error) {
+			retry, err = s.transfer(retiredTable, nil)
+			return
+		}); err != nil {
+			return err
 		}
-	}
-
-	// Retry tables with relations
-	visitedRetriedTables := []string{}
-	for _, retiredTable := range maybeRetry {
-		log.Info().Str("table", retiredTable.Name).Msg("Transferring")
-		if err := relationalCopy(&depth, tables, retiredTable, &visitedRetriedTables, s.source, s.destination); err != nil {
-			log.Warn().Str("table", retiredTable.Name).Msgf("Transferring failed, try increasing fraction percentage")
+		if retry {
+			log.Warn().Str("table", name).Msgf("Transferring failed, try increasing fraction percentage")
+			s.warn(name, "Transferring failed, try increasing fraction percentage")
+			failed[name] = true
 		}
 	}
 
-	// Remove excluded rows and print reports
+	s.copyRequired(tables)
+
+	// Print reports, tables matching exclude rules are not among the copied tables
 	fmt.Println()
 	fmt.Println("Report:")
 	for _, table := range tables {
-		// to ensure no data is in excluded tables
-		for _, exclude := range s.exclude {
-			if exclude.Table == table.Name {
-				log.Info().Str("query", exclude.Where).Msgf("Deleting excluded rows for table %s", table.Name)
-				if err = DeleteRows(exclude.Table, exclude.Where, s.destination); err != nil {
-					return errors.Wrapf(err, "Error deleting excluded rows for table %s", table.Name)
-				}
+		count, _ := CountRows(table.FullName(), s.destination)
+		s.record(table.FullName(), func(t *TableReport) { t.Rows = count })
+		log.Info().Int("count", count).Msgf("Copied table %s", table.FullName())
+	}
+
+	return
+}
+
+// transfer copies a table and its included rows, skipping the steps finished by an earlier
+// sync. Tables with relations that fail to copy are reported for a retry once all other
+// tables are copied.
+func (s *Sync) transfer(table Table, unfinished []string) (retry bool, err error) {
+	copied, err := s.finished(table.FullName(), stepData)
+	if err != nil {
+		return
+	}
+	if copied {
+		log.Info().Str("table", table.FullName()).Msg("Skipping rows copied by an earlier sync")
+		s.record(table.FullName(), func(t *TableReport) { t.Skipped = true })
+	} else {
+		log.Info().Str("table", table.FullName()).Msg("Transferring")
+		if copyErr := s.copyTable(table, unfinished); copyErr != nil {
+			if len(table.Relations) == 0 {
+				return false, copyErr
 			}
+			log.Info().Err(copyErr).Str("table", table.FullName()).Msgf("Transferring failed, retrying later")
+			retry = true
+		} else if err = s.finish(table.FullName(), stepData); err != nil {
+			return
 		}
-
-		count, _ := CountRows(table.Name, s.destination)
-		log.Info().Int("count", count).Msgf("Copied table %s", table.Name)
 	}
 
-	return
+	included, err := s.finished(table.FullName(), stepInclude)
+	if err != nil || included {
+		return
+	}
+	if err = s.copyIncluded(table); err != nil {
+		return
+	}
+	return retry, s.finish(table.FullName(), stepInclude)
 }
 
-// Sync copies a subset of tables from source to destination
-func (s *Sync) Sync() (err error) {
-	var tables []Table
+// targetSet returns tables with the number of rows to copy, sampled as set for the sync
+// or overridden for the table. The rows of tables in the source are kept for TABLESAMPLE.
+func (s *Sync) targetSet(tables []Table) []Table {
+	s.sourceRows = lo.SliceToMap(tables, func(table Table) (string, int) {
+		return table.FullName(), table.Rows
+	})
+	return lo.Map(tables, func(table Table, _ int) Table {
+		table.Rows = s.tableSample(table.FullName()).TargetRows(table.Rows)
+		return table
+	})
+}
 
+// tables returns the tables with rows in the source that are not excluded by rules,
+// and the names of the excluded tables.
+func (s *Sync) tables() (tables []Table, excluded []string, err error) {
 	// Get all tables with rows
-	if tables, err = GetTablesWithRows(s.source); err != nil {
+	if tables, err = GetTablesWithRows(s.schemas, s.source); err != nil {
 		return
 	}
 
 	// Filter out tables that are not in the include list
-	ruleExcludedTables := lo.Map(s.exclude, func(rule Rule, _ int) string {
-		return rule.Table
-	})
 	tables = lo.Filter(tables, func(table Table, _ int) bool {
-		return !lo.Contains(ruleExcludedTables, table.Name) // excluded tables
+		if lo.SomeBy(s.exclude, func(rule Rule) bool {
+			return rule.Matches(table.FullName()) // excluded tables
+		}) {
+			excluded = append(excluded, table.FullName())
+			return false
+		}
+		return true
 	})
+	return
+}
 
-	// Calculate fraction to be copied over
-	if tables = GetTargetSet(s.fraction, tables); err != nil {
+// Sync copies a subset of tables from source to destination
+func (s *Sync) Sync() (err error) {
+	var tables []Table
+
+	if s.destination == nil {
+		return errors.New("No destination database configured")
+	}
+
+	s.reportMutex.Lock()
+	s.report = Report{Started: time.Now(), Tables: []TableReport{}}
+	s.reportMutex.Unlock()
+	defer func() {
+		s.reportMutex.Lock()
+		s.report.Seconds = time.Since(s.report.Started).Seconds()
+		s.reportMutex.Unlock()
+	}()
+
+	var excluded []string
+	if tables, excluded, err = s.tables(); err != nil {
 		return
 	}
 
+	// Calculate fraction to be copied over
+	targets := s.targetSet(tables)
+	for i, table := range tables {
+		s.record(table.FullName(), func(t *TableReport) {
+			t.SourceRows = table.Rows
+			t.TargetRows = targets[i].Rows
+		})
+	}
+	tables = targets
+
+	s.reportMutex.Lock()
+	s.report.Excluded = excluded
+	s.reportMutex.Unlock()
+
 	if s.verbose {
 		log.Info().Strs("tables", lo.Map(tables, func(table Table, _ int) string {
-			return table.Name
+			return table.FullName()
 		})).Msg("Tables to be copied")
 	}
 
+	var schema Schema
+	if s.createSchema {
+		if schema, err = s.createMissingSchema(); err != nil {
+			return
+		}
+	}
+
+	if err = s.checkSchema(tables); err != nil {
+		return
+	}
+
+	if s.truncate {
+		if err = s.truncateTables(tables); err != nil {
+			return
+		}
+	}
+
 	// Copy tables
 	if err = s.CopyTables(tables); err != nil {
 		return
 	}
 
+	if s.createSchema {
+		if err = s.completeSchema(schema); err != nil {
+			return
+		}
+	}
+
 	return
 }
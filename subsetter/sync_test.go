@@ -18,10 +18,42 @@ func TestSync_CopyTables(t *testing.T) {
 		source:      src,
 		destination: dst,
 	}
-	tables := []Table{{"simple", 10, []Relation{}, []Relation{}}}
+	tables := []Table{{"public", "simple", 10, []Relation{}, []Relation{}}}
 
 	if err := s.CopyTables(tables); err != nil {
 		t.Errorf("Sync.CopyTables() error = %v", err)
 	}
 
 }
+
+func TestSync_CopyTablesMaskedKeys(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	initSchema(dst)
+	defer clearSchema(src)
+	defer clearSchema(dst)
+
+	populateTestsWithData(src, "simple", 100)
+
+	s := &Sync{
+		source:      src,
+		destination: dst,
+		keys:        NewMemoryKeyStore(),
+		transforms:  []ColumnTransform{{Table: "public.simple", Column: "id", Transform: swapHex}},
+	}
+	tables, err := GetTablesWithRows(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tables {
+		tables[i].Rows = 10
+	}
+
+	if err := s.CopyTables(tables); err != nil {
+		t.Fatalf("Sync.CopyTables() error = %v", err)
+	}
+	if count, _ := CountRows("relation", dst); count != 10 {
+		t.Errorf("CountRows(relation) = %v, want 10", count)
+	}
+}
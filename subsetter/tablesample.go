@@ -0,0 +1,51 @@
+package subsetter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// TableSampleMethod selects the TABLESAMPLE method reading rows of large tables.
+type TableSampleMethod string
+
+const (
+	// TableSampleSystem reads random pages of a table, the fastest but rows of a page come together.
+	TableSampleSystem TableSampleMethod = "system"
+	// TableSampleBernoulli reads every page and keeps random rows.
+	TableSampleBernoulli TableSampleMethod = "bernoulli"
+)
+
+// TableSampleMethods lists the supported TABLESAMPLE methods.
+var TableSampleMethods = []TableSampleMethod{TableSampleSystem, TableSampleBernoulli}
+
+// DefaultTableSampleRows is the number of rows from which tables are read with TABLESAMPLE.
+const DefaultTableSampleRows = 1000000
+
+// tableSampleMargin is how many more rows than the target TABLESAMPLE reads, as it returns
+// an approximate number of rows. The limit drops the extra rows.
+const tableSampleMargin = 1.5
+
+// TableSampleClause returns the TABLESAMPLE clause reading about target rows out of rows,
+// with a seed the same rows are read from the same table.
+func TableSampleClause(method TableSampleMethod, target int, rows int, seed string) string {
+	percent := min(100, 100*tableSampleMargin*float64(target)/float64(rows))
+	clause := fmt.Sprintf("TABLESAMPLE %s (%s)", strings.ToUpper(string(method)), strconv.FormatFloat(percent, 'g', 4, 64))
+	if seed != "" {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(seed))
+		clause += fmt.Sprintf(" REPEATABLE (%d)", hash.Sum32())
+	}
+	return clause
+}
+
+// tableSampleClause returns the TABLESAMPLE clause reading the target rows of a table, empty unless
+// the table has at least the configured number of rows in the source.
+func (s *Sync) tableSampleClause(table Table) string {
+	rows := s.sourceRows[table.FullName()]
+	if s.tableSampling == "" || rows < s.tableSamplingRows || table.Rows >= rows {
+		return ""
+	}
+	return TableSampleClause(s.tableSampling, table.Rows, rows, s.seed)
+}
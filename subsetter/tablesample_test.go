@@ -0,0 +1,41 @@
+package subsetter
+
+import "testing"
+
+func TestTableSampleClause(t *testing.T) {
+	tests := []struct {
+		name   string
+		method TableSampleMethod
+		target int
+		rows   int
+		seed   string
+		want   string
+	}{
+		{"System", TableSampleSystem, 1000, 10000000, "", "TABLESAMPLE SYSTEM (0.015)"},
+		{"Bernoulli with seed", TableSampleBernoulli, 1000, 3000000, "secret", "TABLESAMPLE BERNOULLI (0.05) REPEATABLE (1719429393)"},
+		{"Capped at all rows", TableSampleSystem, 900000, 1000000, "", "TABLESAMPLE SYSTEM (100)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TableSampleClause(tt.method, tt.target, tt.rows, tt.seed); got != tt.want {
+				t.Errorf("TableSampleClause() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSync_tableSampleClause(t *testing.T) {
+	s := &Sync{tableSampling: TableSampleSystem, tableSamplingRows: 1000000}
+	s.targetSet([]Table{{"public", "events", 10000000, nil, nil}, {"public", "users", 1000, nil, nil}})
+
+	want := `SELECT * FROM "public"."events" TABLESAMPLE SYSTEM (0.015)   LIMIT 1000`
+	if got := s.tableDataQuery(Table{"public", "events", 1000, nil, nil}, nil, true); got != want {
+		t.Errorf("Sync.tableDataQuery() = %q, want %q", got, want)
+	}
+	if got := s.tableSampleClause(Table{"public", "users", 10, nil, nil}); got != "" {
+		t.Errorf("Sync.tableSampleClause() = %q, want no clause below the rows", got)
+	}
+	if got := s.tableDataQuery(Table{"public", "events", 1000, nil, nil}, []string{`"id" > 1`}, true); got != `SELECT * FROM "public"."events" WHERE "id" > 1 order by random() LIMIT 1000` {
+		t.Errorf("Sync.tableDataQuery() = %q, want no clause with related queries", got)
+	}
+}
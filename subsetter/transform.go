@@ -0,0 +1,364 @@
+package subsetter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// Transform masks a column value, a nil result writes NULL. Transforms derive
+// any randomness from the seed and the value only, so the same source value
+// is masked to the same value in every table and column.
+type Transform func(seed []byte, value string) *string
+
+// ColumnTransform masks a column of tables matching a pattern.
+type ColumnTransform struct {
+	Table     string
+	Column    string
+	Transform Transform
+}
+
+// FakeEmail replaces values with a fake email address.
+func FakeEmail() Transform {
+	return func(seed []byte, value string) *string {
+		return lo.ToPtr("user_" + hex.EncodeToString(digest(seed, value))[:12] + "@example.com")
+	}
+}
+
+// Hash replaces values with their keyed hash.
+func Hash() Transform {
+	return func(seed []byte, value string) *string {
+		return lo.ToPtr(hex.EncodeToString(digest(seed, value)))
+	}
+}
+
+// Nullify replaces values with NULL.
+func Nullify() Transform {
+	return func(seed []byte, value string) *string {
+		return nil
+	}
+}
+
+// Fixed replaces values with a fixed value.
+func Fixed(fixed string) Transform {
+	return func(seed []byte, value string) *string {
+		return lo.ToPtr(fixed)
+	}
+}
+
+// KeepFormat replaces letters and digits with random ones of the same kind,
+// keeping the case, punctuation and length of the value.
+func KeepFormat() Transform {
+	return func(seed []byte, value string) *string {
+		runes := []rune(value)
+		random := digestStream(seed, value, len(runes))
+		for i, r := range runes {
+			switch {
+			case unicode.IsDigit(r):
+				runes[i] = '0' + rune(random[i]%10)
+			case unicode.IsUpper(r):
+				runes[i] = 'A' + rune(random[i]%26)
+			case unicode.IsLetter(r):
+				runes[i] = 'a' + rune(random[i]%26)
+			}
+		}
+		return lo.ToPtr(string(runes))
+	}
+}
+
+// dateLayouts are the date and timestamp formats written by COPY with the ISO DateStyle.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999-07:00",
+}
+
+// ShiftDate moves dates and timestamps by up to days in either direction,
+// values that are not dates are kept.
+func ShiftDate(days int) Transform {
+	days = max(days, -days)
+	return func(seed []byte, value string) *string {
+		for _, layout := range dateLayouts {
+			t, err := time.Parse(layout, value)
+			if err != nil {
+				continue
+			}
+			offset := int(binary.BigEndian.Uint64(digest(seed, value)) % uint64(2*days+1))
+			return lo.ToPtr(t.AddDate(0, 0, offset-days).Format(layout))
+		}
+		return &value
+	}
+}
+
+// RegexReplace replaces matches of the pattern, see regexp.Regexp.ReplaceAllString.
+func RegexReplace(pattern string, replacement string) (Transform, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid pattern %s", pattern)
+	}
+	return func(seed []byte, value string) *string {
+		return lo.ToPtr(re.ReplaceAllString(value, replacement))
+	}, nil
+}
+
+// digest returns the keyed hash of a value.
+func digest(seed []byte, value string) []byte {
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// digestStream returns at least n pseudo random bytes derived from a value.
+func digestStream(seed []byte, value string, n int) (stream []byte) {
+	for i := 0; len(stream) < n; i++ {
+		stream = append(stream, digest(seed, value+"\x00"+strconv.Itoa(i))...)
+	}
+	return
+}
+
+// masker masks columns of rows in the COPY text format.
+type masker struct {
+	seed       []byte
+	transforms map[int]Transform
+}
+
+// mask masks all rows of COPY data.
+func (m *masker) mask(data string) string {
+	lines := strings.Split(data, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = m.row(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// row masks a single row of COPY data, without the line ending.
+func (m *masker) row(line string) string {
+	fields := strings.Split(line, "\t")
+	for i, transform := range m.transforms {
+		if i >= len(fields) {
+			continue
+		}
+		if value := decodeCopyField(fields[i]); value != nil {
+			fields[i] = encodeCopyField(transform(m.seed, *value))
+		}
+	}
+	return strings.Join(fields, "\t")
+}
+
+// maskWriter masks rows in the COPY text format written to it, holding back
+// incomplete rows until their line ending arrives.
+type maskWriter struct {
+	m       *masker
+	w       io.Writer
+	pending []byte
+}
+
+func (mw *maskWriter) Write(p []byte) (int, error) {
+	mw.pending = append(mw.pending, p...)
+	i := bytes.LastIndexByte(mw.pending, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(mw.w, mw.m.mask(string(mw.pending[:i+1]))); err != nil {
+		return 0, err
+	}
+	mw.pending = mw.pending[:copy(mw.pending, mw.pending[i+1:])]
+	return len(p), nil
+}
+
+// Flush writes the remaining incomplete row.
+func (mw *maskWriter) Flush() error {
+	if len(mw.pending) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(mw.w, mw.m.mask(string(mw.pending)))
+	mw.pending = mw.pending[:0]
+	return err
+}
+
+// masker returns the masker for a table, nil when no column of the table is masked.
+func (s *Sync) masker(table string) (*masker, error) {
+	if len(s.transforms) == 0 {
+		return nil, nil
+	}
+
+	columns, err := s.sourceColumns(table)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error getting columns for table %s", table)
+	}
+
+	transforms := map[int]Transform{}
+	for i, column := range columns {
+		if transform := s.columnTransform(table, column, 0); transform != nil {
+			transforms[i] = transform
+		}
+	}
+	if len(transforms) == 0 {
+		return nil, nil
+	}
+	return &masker{seed: []byte(s.seed), transforms: transforms}, nil
+}
+
+// maxTransformDepth limits how many foreign keys are followed when looking up transforms.
+const maxTransformDepth = 10
+
+// columnTransform returns the transform of a column. Columns without one that are part
+// of a foreign key use the transform of the referenced column, keeping keys consistent.
+func (s *Sync) columnTransform(table string, column string, depth int) Transform {
+	if transform, ok := lo.Find(s.transforms, func(t ColumnTransform) bool {
+		return t.Column == column && MatchTable(t.Table, table)
+	}); ok {
+		return transform.Transform
+	}
+	if depth >= maxTransformDepth {
+		return nil
+	}
+
+	for _, relation := range GetRelations(QualifiedName(table), s.source) {
+		if i := lo.IndexOf(relation.PrimaryColumns, column); i >= 0 && !relation.IsSelfRelated() {
+			if transform := s.columnTransform(relation.ForeignTable, relation.ForeignColumns[i], depth+1); transform != nil {
+				return transform
+			}
+		}
+	}
+	return nil
+}
+
+// keysMasked reports whether any of the columns of a table are masked, their values in the
+// destination differ from those in the source.
+func (s *Sync) keysMasked(table string, columns []string) bool {
+	return len(s.transforms) > 0 && lo.SomeBy(columns, func(column string) bool {
+		return s.columnTransform(table, column, 0) != nil
+	})
+}
+
+// unmaskedDestinationKeys returns the keys of columns of rows in the destination as their
+// values in the source, so they can filter rows of the source. Masked keys can't be reversed,
+// the keys of source rows matching where are masked instead and kept when in the destination.
+func (s *Sync) unmaskedDestinationKeys(table string, columns []string, where string) ([][]string, error) {
+	q := keysQuery(table, RowKey(table, columns))
+	destination, err := GetKeys(q, s.destination)
+	if err != nil || !s.keysMasked(table, columns) {
+		return destination, err
+	}
+
+	copied := lo.SliceToMap(destination, func(key []string) (string, struct{}) {
+		return strings.Join(key, keySeparator), struct{}{}
+	})
+	transforms := lo.Map(columns, func(column string, _ int) Transform { return s.columnTransform(table, column, 0) })
+	if where != "" {
+		q += " WHERE " + where
+	}
+	source, err := GetKeys(q, s.source)
+	return lo.Filter(source, func(key []string, _ int) bool {
+		masked := make([]string, len(key))
+		for i, value := range key {
+			masked[i] = value
+			if transforms[i] == nil {
+				continue
+			}
+			value := transforms[i]([]byte(s.seed), value)
+			if value == nil {
+				return false
+			}
+			masked[i] = *value
+		}
+		_, ok := copied[strings.Join(masked, keySeparator)]
+		return ok
+	}), err
+}
+
+// copyEscapes maps escaped characters of the COPY text format.
+var copyEscapes = map[byte]byte{'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v'}
+
+// decodeCopyField unescapes a field of the COPY text format, nil is NULL.
+func decodeCopyField(field string) *string {
+	if field == `\N` {
+		return nil
+	}
+	if !strings.Contains(field, `\`) {
+		return &field
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' || i+1 == len(field) {
+			b.WriteByte(field[i])
+			continue
+		}
+		i++
+		c := field[i]
+		switch {
+		case copyEscapes[c] != 0:
+			b.WriteByte(copyEscapes[c])
+		case c >= '0' && c <= '7':
+			end := i + 1
+			for end < len(field) && end < i+3 && field[end] >= '0' && field[end] <= '7' {
+				end++
+			}
+			v, _ := strconv.ParseUint(field[i:end], 8, 8)
+			b.WriteByte(byte(v))
+			i = end - 1
+		case c == 'x' && i+1 < len(field) && isHex(field[i+1]):
+			end := i + 2
+			if end < len(field) && isHex(field[end]) {
+				end++
+			}
+			v, _ := strconv.ParseUint(field[i+1:end], 16, 8)
+			b.WriteByte(byte(v))
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return lo.ToPtr(b.String())
+}
+
+// encodeCopyField escapes a value for the COPY text format, nil is NULL.
+func encodeCopyField(value *string) string {
+	if value == nil {
+		return `\N`
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(*value); i++ {
+		c := (*value)[i]
+		switch c {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,82 @@
+package subsetter
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/samber/lo"
+)
+
+func TestCopyField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  *string
+	}{
+		{"Plain", "test", lo.ToPtr("test")},
+		{"Null", `\N`, nil},
+		{"Escapes", `a\tb\nc\\d`, lo.ToPtr("a\tb\nc\\d")},
+		{"Octal and hex", `\101\x42`, lo.ToPtr("AB")},
+		{"Bytea", `\\x0102`, lo.ToPtr(`\x0102`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeCopyField(tt.field)
+			if lo.FromPtr(got) != lo.FromPtr(tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("decodeCopyField() = %v, want %v", got, tt.want)
+			}
+			if tt.name != "Octal and hex" && encodeCopyField(got) != tt.field {
+				t.Errorf("encodeCopyField() = %v, want %v", encodeCopyField(got), tt.field)
+			}
+		})
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	seed := []byte("seed")
+	replace, _ := RegexReplace(`\d`, "X")
+
+	tests := []struct {
+		name      string
+		transform Transform
+		value     string
+		want      *regexp.Regexp
+	}{
+		{"Fake email", FakeEmail(), "john@doe.com", regexp.MustCompile(`^user_[0-9a-f]{12}@example\.com$`)},
+		{"Hash", Hash(), "john", regexp.MustCompile(`^[0-9a-f]{64}$`)},
+		{"Fixed", Fixed("secret"), "john", regexp.MustCompile(`^secret$`)},
+		{"Keep format", KeepFormat(), "Ab-12 c", regexp.MustCompile(`^[A-Z][a-z]-\d\d [a-z]$`)},
+		{"Shift date", ShiftDate(30), "2024-01-31", regexp.MustCompile(`^2024-0[1-3]-\d\d$`)},
+		{"Shift timestamp", ShiftDate(1), "2024-01-31 10:00:00.5+02", regexp.MustCompile(`^2024-0[12]-\d\d 10:00:00\.5\+02$`)},
+		{"Shift other", ShiftDate(1), "infinity", regexp.MustCompile(`^infinity$`)},
+		{"Regex replace", replace, "555-123", regexp.MustCompile(`^XXX-XXX$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.transform(seed, tt.value)
+			if got == nil || !tt.want.MatchString(*got) {
+				t.Fatalf("Transform() = %v, want %v", lo.FromPtr(got), tt.want)
+			}
+			if again := tt.transform(seed, tt.value); *again != *got {
+				t.Errorf("Transform() = %v, want deterministic %v", *again, *got)
+			}
+		})
+	}
+
+	if Nullify()(seed, "john") != nil {
+		t.Errorf("Nullify() should return NULL")
+	}
+	if *Hash()(seed, "john") == *Hash()([]byte("other"), "john") {
+		t.Errorf("Hash() should depend on the seed")
+	}
+}
+
+func TestMasker_mask(t *testing.T) {
+	m := &masker{seed: []byte("seed"), transforms: map[int]Transform{1: Fixed("x\ty"), 2: Nullify()}}
+
+	got := m.mask("1\tjohn\tsecret\n2\t\\N\tsecret\n")
+	want := "1\tx\\ty\t\\N\n2\t\\N\t\\N\n"
+	if got != want {
+		t.Errorf("masker.mask() = %q, want %q", got, want)
+	}
+}
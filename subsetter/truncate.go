@@ -0,0 +1,105 @@
+package subsetter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+// truncateOrder returns the names of tables with tables referencing others before the
+// tables they reference, the reverse of the order they are copied in.
+func truncateOrder(tables []Table) []string {
+	schedule := newSchedule(tables)
+	order := []string{}
+	for schedule.pending() {
+		name, ok := schedule.next()
+		if !ok {
+			name, _ = schedule.breakCycle()
+		}
+		schedule.finish(name)
+		order = append(order, name)
+	}
+	slices.Reverse(order)
+	return order
+}
+
+// TruncateQuery returns the query truncating tables in one statement, with cascade also
+// the tables referencing them.
+func TruncateQuery(tables []string, cascade bool) string {
+	q := fmt.Sprintf("TRUNCATE %s", strings.Join(lo.Map(tables, func(table string, _ int) string {
+		return QuoteTable(table)
+	}), ", "))
+	if cascade {
+		q += " CASCADE"
+	}
+	return q
+}
+
+// SameDatabase reports whether two connections reach the same database of the same server,
+// however the server was addressed.
+func SameDatabase(a *pgxpool.Pool, b *pgxpool.Pool) (bool, error) {
+	ia, err := databaseIdentity(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := databaseIdentity(b)
+	if err != nil {
+		return false, err
+	}
+	return ia == ib, nil
+}
+
+// databaseIdentity returns the system identifier of the server and the name of the database.
+// Roles that can't read the control data get the address and port the server sees instead.
+func databaseIdentity(conn *pgxpool.Pool) (identity string, err error) {
+	q := `SELECT system_identifier::text || '/' || current_database() FROM pg_control_system()`
+	if err = conn.QueryRow(context.Background(), q).Scan(&identity); err == nil {
+		return
+	}
+	q = `SELECT coalesce(host(inet_server_addr()), 'local') || ':' || current_setting('port') || '/' || current_database()`
+	if err = conn.QueryRow(context.Background(), q).Scan(&identity); err != nil {
+		return "", errors.Wrap(err, "Error identifying database")
+	}
+	return
+}
+
+// truncateTables truncates the destination tables about to be copied, except tables
+// copied by an earlier sync that is resumed.
+func (s *Sync) truncateTables(tables []Table) (err error) {
+	truncated := []Table{}
+	for _, table := range tables {
+		copied, err := s.finished(table.FullName(), stepData)
+		if err != nil {
+			return err
+		}
+		if !copied {
+			truncated = append(truncated, table)
+		}
+	}
+	if len(truncated) == 0 {
+		return
+	}
+
+	q := TruncateQuery(truncateOrder(truncated), s.cascade)
+	log.Info().Str("query", q).Msg("Truncating destination tables")
+	if _, err = s.destination.Exec(context.Background(), q); err != nil {
+		if !s.cascade {
+			return errors.Wrapf(err, "Error truncating destination tables, tables that are not copied may reference them, see cascade")
+		}
+		return errors.Wrapf(err, "Error truncating destination tables")
+	}
+
+	// Keys recorded by earlier syncs no longer exist in the destination
+	for _, table := range truncated {
+		if err = s.keys.Remove(table.FullName()); err != nil {
+			return errors.Wrapf(err, "Error removing keys of table %s", table.FullName())
+		}
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package subsetter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTruncateOrder(t *testing.T) {
+	tables := []Table{
+		{"public", "users", 10, []Relation{}, []Relation{}},
+		{"public", "items", 10, []Relation{{"public.items", []string{"order_id"}, "public.orders", []string{"id"}}}, []Relation{}},
+		{"public", "orders", 10, []Relation{{"public.orders", []string{"user_id"}, "public.users", []string{"id"}}}, []Relation{}},
+	}
+	want := []string{"public.items", "public.orders", "public.users"}
+	if got := truncateOrder(tables); !reflect.DeepEqual(got, want) {
+		t.Errorf("truncateOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestTruncateQuery(t *testing.T) {
+	tables := []string{"public.items", "public.users"}
+	if got, want := TruncateQuery(tables, false), `TRUNCATE "public"."items", "public"."users"`; got != want {
+		t.Errorf("TruncateQuery() = %v, want %v", got, want)
+	}
+	if got, want := TruncateQuery(tables, true), `TRUNCATE "public"."items", "public"."users" CASCADE`; got != want {
+		t.Errorf("TruncateQuery() = %v, want %v", got, want)
+	}
+}
+
+func TestSameDatabase(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+
+	if same, err := SameDatabase(src, src); err != nil || !same {
+		t.Errorf("SameDatabase() = %v, %v, want the source to be the same database", same, err)
+	}
+	if same, err := SameDatabase(src, dst); err != nil || same {
+		t.Errorf("SameDatabase() = %v, %v, want the destination to be another database", same, err)
+	}
+}
+
+func TestSync_truncateTables(t *testing.T) {
+	src := getTestConnection()
+	dst := getTestConnectionDst()
+	initSchema(src)
+	initSchema(dst)
+	defer clearSchema(src)
+	defer clearSchema(dst)
+
+	populateTestsWithData(dst, "simple", 10)
+
+	s := &Sync{source: src, destination: dst, keys: NewMemoryKeyStore(), truncate: true}
+	tables, err := GetTablesWithRows(nil, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.truncateTables([]Table{TableByName(tables, "simple")}); err == nil {
+		t.Error("Sync.truncateTables() truncated a table referenced by a table that is not truncated")
+	}
+	if err := s.truncateTables(tables); err != nil {
+		t.Fatalf("Sync.truncateTables() error = %v", err)
+	}
+	for _, table := range []string{"simple", "relation"} {
+		if count, _ := CountRows(table, dst); count != 0 {
+			t.Errorf("CountRows(%s) = %v, want 0", table, count)
+		}
+	}
+}
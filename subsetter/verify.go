@@ -0,0 +1,112 @@
+package subsetter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+// verifySamples is the number of offending keys reported per foreign key.
+const verifySamples = 5
+
+// Violation is a foreign key with rows referencing missing rows, Samples holds
+// some of the offending keys.
+type Violation struct {
+	Relation Relation
+	Count    int
+	Samples  [][]string
+}
+
+func (v *Violation) String() string {
+	return fmt.Sprintf("%s (%s) -> %s (%s): %d orphaned rows, e.g. %s",
+		v.Relation.PrimaryTable, strings.Join(v.Relation.PrimaryColumns, ", "),
+		v.Relation.ForeignTable, strings.Join(v.Relation.ForeignColumns, ", "),
+		v.Count, keyValues(v.Samples))
+}
+
+// orphansCondition returns the condition matching rows of the primary table that reference
+// missing rows of the foreign table, rows with a NULL in the key reference nothing.
+func orphansCondition(relation Relation) string {
+	primary, foreign := qualifyColumns("c", relation.PrimaryColumns), qualifyColumns("p", relation.ForeignColumns)
+	notNull := lo.Map(primary, func(column string, _ int) string {
+		return column + " IS NOT NULL"
+	})
+	matches := lo.Map(primary, func(column string, i int) string {
+		return foreign[i] + " = " + column
+	})
+	return fmt.Sprintf("%s AND NOT EXISTS (SELECT 1 FROM %s AS p WHERE %s)",
+		strings.Join(notNull, " AND "), QuoteTable(relation.ForeignTable), strings.Join(matches, " AND "))
+}
+
+// OrphansCountQuery returns the query counting rows that violate a foreign key.
+func OrphansCountQuery(relation Relation) string {
+	return fmt.Sprintf("SELECT count(*) FROM %s AS c WHERE %s", QuoteTable(relation.PrimaryTable), orphansCondition(relation))
+}
+
+// OrphansQuery returns the query selecting the keys of rows that violate a foreign key.
+func OrphansQuery(relation Relation, limit int) string {
+	columns := lo.Map(qualifyColumns("c", relation.PrimaryColumns), func(column string, _ int) string {
+		return column + "::text"
+	})
+	return fmt.Sprintf("SELECT %s FROM %s AS c WHERE %s LIMIT %d",
+		strings.Join(columns, ", "), QuoteTable(relation.PrimaryTable), orphansCondition(relation), limit)
+}
+
+// Verify checks every foreign key of the copied schemas in the destination database
+// and returns the foreign keys with rows referencing missing rows. Foreign keys are read
+// from the destination catalog, not from the relations cached for the source.
+func (s *Sync) Verify() (violations []Violation, err error) {
+	if s.destination == nil {
+		return nil, errors.New("No destination database configured")
+	}
+
+	tables, err := GetTablesWithRows(s.schemas, s.destination)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting tables")
+	}
+
+	relations, err := queryRelations(s.destination)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting foreign keys")
+	}
+
+	for _, table := range tables {
+		for _, relation := range relations {
+			if relation.PrimaryTable != table.FullName() {
+				continue
+			}
+			log.Debug().Str("table", relation.PrimaryTable).Str("foreign", relation.ForeignTable).Msg("Verifying")
+
+			violation := Violation{Relation: relation}
+			if err = s.destination.QueryRow(context.Background(), OrphansCountQuery(relation)).Scan(&violation.Count); err != nil {
+				return nil, errors.Wrapf(err, "Error verifying table %s", relation.PrimaryTable)
+			}
+			if violation.Count == 0 {
+				continue
+			}
+
+			if violation.Samples, err = GetKeys(OrphansQuery(relation, verifySamples), s.destination); err != nil {
+				return nil, errors.Wrapf(err, "Error getting orphaned rows of table %s", relation.PrimaryTable)
+			}
+			violations = append(violations, violation)
+		}
+	}
+	return
+}
+
+// PrintViolations writes violations in a human readable form.
+func PrintViolations(w io.Writer, violations []Violation) {
+	if len(violations) == 0 {
+		fmt.Fprintln(w, "No foreign key violations found")
+		return
+	}
+	fmt.Fprintf(w, "Foreign key violations: %d\n", len(violations))
+	for _, violation := range violations {
+		fmt.Fprintf(w, "- %s\n", violation.String())
+	}
+}
@@ -0,0 +1,49 @@
+package subsetter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOrphansQuery(t *testing.T) {
+	relation := Relation{"public.memberships", []string{"user_id", "group_id"}, "public.user_groups", []string{"user_id", "group_id"}}
+
+	want := `SELECT count(*) FROM "public"."memberships" AS c WHERE c."user_id" IS NOT NULL AND c."group_id" IS NOT NULL AND NOT EXISTS (SELECT 1 FROM "public"."user_groups" AS p WHERE p."user_id" = c."user_id" AND p."group_id" = c."group_id")`
+	if got := OrphansCountQuery(relation); got != want {
+		t.Errorf("OrphansCountQuery() = %v, want %v", got, want)
+	}
+
+	want = `SELECT c."user_id"::text, c."group_id"::text FROM "public"."memberships" AS c WHERE c."user_id" IS NOT NULL AND c."group_id" IS NOT NULL AND NOT EXISTS (SELECT 1 FROM "public"."user_groups" AS p WHERE p."user_id" = c."user_id" AND p."group_id" = c."group_id") LIMIT 5`
+	if got := OrphansQuery(relation, 5); got != want {
+		t.Errorf("OrphansQuery() = %v, want %v", got, want)
+	}
+}
+
+func TestSync_Verify(t *testing.T) {
+	dst := getTestConnectionDst()
+	initSchema(dst)
+	defer clearSchema(dst)
+
+	populateTestsWithData(dst, "simple", 10)
+
+	s := &Sync{destination: dst}
+	if violations, err := s.Verify(); err != nil || len(violations) != 0 {
+		t.Fatalf("Sync.Verify() = %v, %v, want no violations", violations, err)
+	}
+
+	if _, err := dst.Exec(context.Background(), `
+		ALTER TABLE relation DROP CONSTRAINT relation_simple_fk;
+		DELETE FROM simple;
+		ALTER TABLE relation ADD CONSTRAINT relation_simple_fk FOREIGN KEY (simple_id) REFERENCES simple(id) NOT VALID;
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	violations, err := s.Verify()
+	if err != nil {
+		t.Fatalf("Sync.Verify() error = %v", err)
+	}
+	if len(violations) != 1 || violations[0].Count != 10 || len(violations[0].Samples) != verifySamples {
+		t.Errorf("Sync.Verify() = %v, want 10 orphaned rows of relation", violations)
+	}
+}